@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	log "github.com/sirupsen/logrus"
+
+	fkt "github.com/clingclangclick/fkt/fkt"
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+type Selectors struct {
+	Cluster  []string `short:"c" help:"Cluster path glob patterns to process, e.g. platform/*"`
+	Resource []string `short:"r" help:"Resource names to process"`
+	Selector []string `short:"S" help:"Cluster annotation selectors, e.g. region=eu, region!=us, name"`
+}
+
+func (selectors *Selectors) apply(config *fkt.Config) error {
+	err := config.Select(fkt.Selector{
+		Clusters:  selectors.Cluster,
+		Resources: selectors.Resource,
+		Labels:    selectors.Selector,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+
+	return nil
+}
+
+type RenderCmd struct {
+	Selectors `embed:""`
+	Report    string `type:"path" help:"Write a JSON report of the run to this file"`
+	DryRun    bool   `short:"d" hidden:"" help:"Deprecated, use diff" env:"DRY_RUN" default:"false"`
+	Validate  bool   `short:"v" hidden:"" help:"Deprecated, use validate" env:"VALIDATE" default:"false"`
+}
+
+func writeReport(config *fkt.Config, path string, err error) error {
+	if path == "" || config.Report() == nil {
+		return nil
+	}
+
+	log.Info("Writing report: ", utils.RelWD(path))
+	return config.Report().Write(path, err)
+}
+
+// Exits 0 on success, 1 on error.
+func (cmd *RenderCmd) Run(globals *Globals) error {
+	if cmd.Validate {
+		return (&ValidateCmd{}).Run(globals)
+	}
+	if cmd.DryRun {
+		return (&DiffCmd{Selectors: cmd.Selectors, Report: cmd.Report}).Run(globals)
+	}
+
+	config, err := globals.validate(false)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Selectors.apply(config)
+	if err != nil {
+		return err
+	}
+
+	processErr := config.Process()
+	err = writeReport(config, cmd.Report, processErr)
+	if processErr != nil {
+		return fmt.Errorf("error processing configuration: %s (%w)", globals.configFiles(), processErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type DiffCmd struct {
+	Selectors `embed:""`
+	Report    string `type:"path" help:"Write a JSON report of the run to this file"`
+}
+
+// Exits 0 without differences, 1 with differences and 2 on error.
+func (cmd *DiffCmd) Run(globals *Globals) error {
+	config, err := globals.validate(true)
+	if err != nil {
+		return exitError{code: 2, err: err}
+	}
+
+	err = cmd.Selectors.apply(config)
+	if err != nil {
+		return exitError{code: 2, err: err}
+	}
+
+	processErr := config.Process()
+	err = writeReport(config, cmd.Report, processErr)
+	if processErr != nil {
+		return exitError{code: 2, err: fmt.Errorf("error processing configuration: %s (%w)", globals.configFiles(), processErr)}
+	}
+	if err != nil {
+		return exitError{code: 2, err: err}
+	}
+
+	diffs, err := utils.DryRunDiff(globals.BaseDirectory)
+	if err != nil {
+		return exitError{code: 2, err: fmt.Errorf("error generating differences: %w", err)}
+	}
+	for _, diff := range diffs {
+		fmt.Print(diff)
+	}
+	if len(diffs) > 0 {
+		log.Error("Dry run found ", len(diffs), " changed files")
+		return exitError{code: 1}
+	}
+
+	return nil
+}
+
+type ValidateCmd struct {
+	Manifests bool `help:"Render in memory and validate the rendered manifests"`
+	Build     bool `help:"Render in memory and kustomize build the rendered clusters"`
+}
+
+// Exits 0 when valid, 1 when invalid.
+func (cmd *ValidateCmd) Run(globals *Globals) error {
+	render := cmd.Manifests || cmd.Build
+	config, err := globals.validate(render)
+	if err != nil || !render {
+		return err
+	}
+
+	config.Settings.Validation.Manifests = config.Settings.Validation.Manifests || cmd.Manifests
+	config.Settings.Validation.Build = config.Settings.Validation.Build || cmd.Build
+	err = config.Process()
+	if err != nil {
+		return fmt.Errorf("error validating manifests: %s (%w)", globals.configFiles(), err)
+	}
+
+	return nil
+}
+
+type BuildCmd struct {
+	Cluster string `arg:"" help:"Cluster path to build."`
+}
+
+// Exits 0 on success, 1 on error.
+func (cmd *BuildCmd) Run(globals *Globals) error {
+	config, err := globals.validate(true)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := config.Clusters[cmd.Cluster]; !ok {
+		return fmt.Errorf("cluster does not exist: %s", cmd.Cluster)
+	}
+	err = config.Select(fkt.Selector{Clusters: []string{cmd.Cluster}})
+	if err != nil {
+		return err
+	}
+
+	err = config.Process()
+	if err != nil {
+		return fmt.Errorf("error processing configuration: %s (%w)", globals.configFiles(), err)
+	}
+
+	built, err := config.Build(cmd.Cluster)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(built))
+
+	return nil
+}
+
+type ListCmd struct {
+	Kind string `arg:"" enum:"clusters,resources,templates" help:"One of clusters, resources, templates."`
+}
+
+// Exits 0 on success, 1 on error.
+func (cmd *ListCmd) Run(globals *Globals) error {
+	config, err := globals.load(false)
+	if err != nil {
+		return err
+	}
+
+	var items []string
+	switch cmd.Kind {
+	case "clusters":
+		for clusterPath := range config.Clusters {
+			items = append(items, clusterPath)
+		}
+	case "resources":
+		for clusterPath, cluster := range config.Clusters {
+			if cluster == nil {
+				continue
+			}
+			for resourceName := range cluster.Resources {
+				items = append(items, clusterPath+"/"+resourceName)
+			}
+		}
+	case "templates":
+		items, err = config.Templates()
+		if err != nil {
+			return err
+		}
+	}
+
+	slices.Sort(items)
+	for _, item := range items {
+		fmt.Println(item)
+	}
+
+	return nil
+}
+
+type ExplainCmd struct {
+	Resource string `arg:"" help:"Cluster resource as <cluster path>/<resource>."`
+}
+
+// Exits 0 on success, 1 on error.
+func (cmd *ExplainCmd) Run(globals *Globals) error {
+	separator := strings.LastIndex(cmd.Resource, "/")
+	if separator < 1 {
+		return fmt.Errorf("resource must be <cluster path>/<resource>: %s", cmd.Resource)
+	}
+
+	config, err := globals.load(false)
+	if err != nil {
+		return err
+	}
+
+	explanation, err := config.Explain(cmd.Resource[:separator], cmd.Resource[separator+1:])
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+
+	return encoder.Encode(explanation)
+}
+
+type SchemaCmd struct{}
+
+// Exits 0 on success, 1 on error.
+func (cmd *SchemaCmd) Run(globals *Globals) error {
+	schema, err := json.MarshalIndent(fkt.JSONSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(schema))
+
+	return nil
+}
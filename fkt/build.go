@@ -0,0 +1,147 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+// Copies the base directory into memory, through the dry-run view, so rendered
+// overlays are built whether or not they were written. Overlays may refer to
+// resources and components outside of the target directory, hidden
+// directories are skipped.
+func (settings *Settings) buildFileSystem() (filesys.FileSystem, error) {
+	fileSystem := filesys.MakeFsInMemory()
+
+	var copyDirectory func(path, memoryPath string) error
+	copyDirectory = func(path, memoryPath string) error {
+		err := fileSystem.MkdirAll(memoryPath)
+		if err != nil {
+			return err
+		}
+
+		entries, err := utils.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			entryPath := filepath.Join(path, entry.Name())
+			entryMemoryPath := filepath.Join(memoryPath, entry.Name())
+			if entry.IsDir() {
+				err = copyDirectory(entryPath, entryMemoryPath)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			b, err := utils.ReadFile(entryPath)
+			if err != nil {
+				return err
+			}
+			err = fileSystem.WriteFile(entryMemoryPath, b)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := copyDirectory(settings.Directories.baseDirectory, string(filepath.Separator))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read base directory: %w", err)
+	}
+
+	return fileSystem, nil
+}
+
+func buildKustomization(fileSystem filesys.FileSystem, path string) ([]byte, error) {
+	kustomizer := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resources, err := kustomizer.Run(fileSystem, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return resources.AsYaml()
+}
+
+// Builds the cluster overlay and the overlays of the resources applied by Flux
+// Kustomizations, which the cluster overlay does not include.
+func (config *Config) buildCluster(fileSystem filesys.FileSystem, clusterPath string) ([]byte, error) {
+	clusterTargetPath := filepath.Join(string(filepath.Separator), config.Settings.Directories.Target, clusterPath)
+	built, err := buildKustomization(fileSystem, clusterTargetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, ok := config.Clusters[clusterPath]
+	if !ok {
+		return built, nil
+	}
+	for _, resourceName := range cluster.fluxResources() {
+		log.Debug("Building flux resource: ", resourceName)
+		builtResource, err := buildKustomization(fileSystem, filepath.Join(clusterTargetPath, resourceName))
+		if err != nil {
+			return nil, fmt.Errorf("resource %s: %w", resourceName, err)
+		}
+		if len(built) > 0 && len(builtResource) > 0 {
+			built = append(built, "---\n"...)
+		}
+		built = append(built, builtResource...)
+	}
+
+	return built, nil
+}
+
+// Kustomize build of the rendered cluster overlay.
+func (config *Config) Build(clusterPath string) ([]byte, error) {
+	fileSystem, err := config.Settings.buildFileSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	built, err := config.buildCluster(fileSystem, clusterPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build cluster: %s; %w", clusterPath, err)
+	}
+
+	return built, nil
+}
+
+// Builds every cluster rendered by the run, failing on any build error.
+func (config *Config) buildClusters() error {
+	log.Info("Building rendered clusters...")
+	fileSystem, err := config.Settings.buildFileSystem()
+	if err != nil {
+		return err
+	}
+
+	clusterPaths := make([]string, 0, len(config.report.Clusters))
+	for clusterPath := range config.report.Clusters {
+		clusterPaths = append(clusterPaths, clusterPath)
+	}
+	slices.Sort(clusterPaths)
+
+	var buildErrors []error
+	for _, clusterPath := range clusterPaths {
+		log.Debug("Building cluster: ", clusterPath)
+		_, err := config.buildCluster(fileSystem, clusterPath)
+		if err != nil {
+			buildErrors = append(buildErrors, fmt.Errorf("cluster %s: %w", clusterPath, err))
+		}
+	}
+
+	return errors.Join(buildErrors...)
+}
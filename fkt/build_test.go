@@ -0,0 +1,105 @@
+package fkt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildClusterFluxResources(t *testing.T) {
+	kustomization := func(resources ...string) string {
+		return "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n  - " + strings.Join(resources, "\n  - ") + "\n"
+	}
+	configMap := func(name string) string {
+		return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		built []string
+		err   string
+	}{
+		{
+			name: "flux resource built",
+			files: map[string]string{
+				"clusters/dev/web/kustomization.yaml": kustomization("configmap.yaml"),
+				"clusters/dev/web/configmap.yaml":     configMap("web"),
+			},
+			built: []string{"name: app", "name: web"},
+		},
+		{
+			name: "flux resource failure",
+			files: map[string]string{
+				"clusters/dev/web/kustomization.yaml": kustomization("missing.yaml"),
+			},
+			err: "cannot build cluster: dev; resource web: ",
+		},
+		{
+			name: "component outside of the target directory",
+			files: map[string]string{
+				"clusters/dev/web/kustomization.yaml": kustomization("configmap.yaml") + "components:\n  - ../../../components/labels\n",
+				"clusters/dev/web/configmap.yaml":     configMap("web"),
+				"components/labels/kustomization.yaml": "apiVersion: kustomize.config.k8s.io/v1alpha1\nkind: Component\n" +
+					"commonLabels:\n  team: platform\n",
+			},
+			built: []string{"name: app", "name: web", "team: platform"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := testSettings(t)
+			settings.Directories.Target = "clusters"
+
+			files := map[string]string{
+				"clusters/dev/kustomization.yaml":     kustomization("app", "flux.yaml"),
+				"clusters/dev/flux.yaml":              configMap("flux"),
+				"clusters/dev/app/kustomization.yaml": kustomization("configmap.yaml"),
+				"clusters/dev/app/configmap.yaml":     configMap("app"),
+			}
+			for path, contents := range test.files {
+				files[path] = contents
+			}
+			for path, contents := range files {
+				path = filepath.Join(settings.Directories.baseDirectory, path)
+				err := os.MkdirAll(filepath.Dir(path), 0o755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(path, []byte(contents), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			managed := true
+			config := &Config{
+				Settings: settings,
+				Clusters: map[string]*Cluster{
+					"dev": {Resources: map[string]*Resource{
+						"app": {Managed: &managed},
+						"web": {Managed: &managed, Flux: &Flux{}},
+					}},
+				},
+			}
+
+			built, err := config.Build("dev")
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("Build() error = %v, want prefix %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			for _, want := range test.built {
+				if !strings.Contains(string(built), want) {
+					t.Errorf("Build() = %s, want %q", built, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package fkt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+const chartManifestsFile = "manifests.yaml"
+
+// Helm chart rendered with `helm template` as the source of a resource, in
+// place of a template directory. The path is a chart directory or packaged
+// .tgz, relative to the templates directory.
+type Chart struct {
+	Path        string   `yaml:"path"`
+	ReleaseName string   `yaml:"release_name"`
+	IncludeCRDs bool     `yaml:"include_crds"`
+	KubeVersion string   `yaml:"kube_version"`
+	APIVersions []string `yaml:"api_versions"`
+}
+
+func (chart *Chart) path(settings *Settings) string {
+	if filepath.IsAbs(chart.Path) {
+		return chart.Path
+	}
+
+	return filepath.Join(settings.pathTemplates(), chart.Path)
+}
+
+func (chart *Chart) validate(settings *Settings) error {
+	if chart.Path == "" {
+		return errors.New("chart path unset")
+	}
+
+	chartPath := chart.path(settings)
+	if strings.HasSuffix(chartPath, ".tgz") {
+		isFile, err := utils.IsFile(chartPath)
+		if !isFile || err != nil {
+			return fmt.Errorf("chart archive does not exist: %s", chart.Path)
+		}
+		return nil
+	}
+
+	isFile, err := utils.IsFile(filepath.Join(chartPath, "Chart.yaml"))
+	if !isFile || err != nil {
+		return fmt.Errorf("Chart.yaml does not exist in: %s", chart.Path)
+	}
+
+	return nil
+}
+
+// Charts are rendered by the helm binary in the PATH.
+func helmBinary() (string, error) {
+	helm, err := exec.LookPath("helm")
+	if err != nil {
+		return "", fmt.Errorf("helm is required to render charts: %w", err)
+	}
+
+	return helm, nil
+}
+
+func (chart *Chart) render(settings *Settings, values Values, releaseName, namespace string) ([]byte, error) {
+	helm, err := helmBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	chartValues, err := yaml.Marshal(values["Values"])
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal chart values: %w", err)
+	}
+
+	args := []string{"template", releaseName, chart.path(settings), "--namespace", namespace, "--values", "-"}
+	if chart.IncludeCRDs {
+		args = append(args, "--include-crds")
+	}
+	if chart.KubeVersion != "" {
+		args = append(args, "--kube-version", chart.KubeVersion)
+	}
+	for _, apiVersion := range chart.APIVersions {
+		args = append(args, "--api-versions", apiVersion)
+	}
+
+	log.Debug("Rendering chart: ", helm, " ", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(helm, args...)
+	cmd.Stdin = bytes.NewReader(chartValues)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("helm template failed: %s; %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Renders the chart into the resource overlay as a single manifests file,
+// documents matching an encryption rule are encrypted.
+func (r *Resource) processChart(settings *Settings, values Values, secrets *Secrets, report *ResourceReport, clusterPath string) error {
+	releaseName := r.Chart.ReleaseName
+	if releaseName == "" {
+		releaseName = r.Name
+	}
+
+	manifests, err := r.Chart.render(settings, values, releaseName, *r.Namespace)
+	if err != nil {
+		return fmt.Errorf("cannot render chart: %s; %w", r.Chart.Path, err)
+	}
+
+	manifestsPath := filepath.Join(r.pathRendered(settings, clusterPath), chartManifestsFile)
+	existingSecrets, err := readEncryptedSecrets(manifestsPath)
+	if err != nil {
+		return err
+	}
+
+	var documents []string
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(manifests)))
+	for {
+		buf, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		buf = bytes.TrimPrefix(buf, []byte("---\n"))
+		if len(bytes.TrimSpace(buf)) == 0 {
+			continue
+		}
+
+		rule, err := matchEncryptionRule(secrets.encryption, buf)
+		if err != nil {
+			return fmt.Errorf("cannot parse rendered chart document: %s; %w", r.Chart.Path, err)
+		}
+		if rule == nil {
+			documents = append(documents, string(buf))
+			continue
+		}
+		if secrets.keys == nil {
+			return fmt.Errorf("document to encrypt rendered but no sops keys or age public key exist for cluster: %s", r.Chart.Path)
+		}
+		document, err := secrets.encryptDocument(string(buf), rule, existingSecrets, manifestsPath, report)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+	}
+
+	return r.writeOverlay(settings, clusterPath, chartManifestsFile, documents, report)
+}
+
+// Writes the documents as the only file of the resource overlay, with a
+// kustomization of it.
+func (r *Resource) writeOverlay(settings *Settings, clusterPath, fileName string, documents []string, report *ResourceReport) error {
+	clusterResourcePath := r.pathRendered(settings, clusterPath)
+	err := utils.MkDir(clusterResourcePath, settings.DryRun)
+	if err != nil {
+		return err
+	}
+
+	removed, err := utils.RemoveFilesAndDirectoriesExcept(clusterResourcePath, []string{fileName, "kustomization.yaml"}, settings.DryRun)
+	for _, removedPath := range removed {
+		report.file(removedPath, FileRemoved)
+	}
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(clusterResourcePath, fileName)
+	action, err := writeFile(filePath, []byte(strings.Join(documents, "---\n")), settings.DryRun)
+	if err != nil {
+		return err
+	}
+	report.file(filePath, action)
+
+	kustomizationYAML, err := yaml.Marshal(&Kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  []string{fileName},
+	})
+	if err != nil {
+		return fmt.Errorf("cannot marshal kustomization: %w", err)
+	}
+	kustomizationPath := filepath.Join(clusterResourcePath, "kustomization.yaml")
+	action, err = writeFile(kustomizationPath, kustomizationYAML, settings.DryRun)
+	if err != nil {
+		return err
+	}
+	report.file(kustomizationPath, action)
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package fkt
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	decrypt "github.com/getsops/sops/v3/decrypt"
+	"gopkg.in/yaml.v3"
+)
+
+func testChart(t *testing.T, settings *Settings) {
+	t.Helper()
+
+	files := map[string]string{
+		"demo/Chart.yaml":               "apiVersion: v2\nname: demo\nversion: 0.1.0\n",
+		"demo/templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n  namespace: {{ .Release.Namespace }}\ndata:\n  mode: {{ .Values.mode }}\n",
+		"demo/templates/secret.yaml":    "apiVersion: v1\nkind: Secret\nmetadata:\n  name: {{ .Release.Name }}\nstringData:\n  password: hunter2\n",
+	}
+	for path, contents := range files {
+		path = filepath.Join(settings.pathTemplates(), path)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Records the arguments and the values read from stdin, and prints the
+// manifests the test chart renders.
+const testHelmStub = `#!/bin/sh
+printf '%s\n' "$@" > "$FKT_TEST_HELM_ARGS"
+cat > "$FKT_TEST_HELM_VALUES"
+cat <<'MANIFESTS'
+---
+# Source: demo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: release
+  namespace: team-a
+data:
+  mode: a
+---
+# Source: demo/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: release
+stringData:
+  password: hunter2
+MANIFESTS
+`
+
+// Puts a stub helm first in the PATH, returns the files it records its
+// arguments and values to.
+func testHelm(t *testing.T) (argsPath, valuesPath string) {
+	t.Helper()
+
+	helmPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(helmPath, "helm"), []byte(testHelmStub), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", helmPath+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	argsPath = filepath.Join(t.TempDir(), "args")
+	valuesPath = filepath.Join(t.TempDir(), "values")
+	t.Setenv("FKT_TEST_HELM_ARGS", argsPath)
+	t.Setenv("FKT_TEST_HELM_VALUES", valuesPath)
+
+	return argsPath, valuesPath
+}
+
+func TestResourceValidateChartRequiresHelm(t *testing.T) {
+	settings := testSettings(t)
+	testChart(t, settings)
+
+	helmPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(helmPath, "helm"), []byte(testHelmStub), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		managed bool
+		err     string
+	}{
+		{name: "helm installed", path: helmPath, managed: true},
+		{name: "helm missing", path: t.TempDir(), managed: true, err: "helm is required to render charts"},
+		{name: "unmanaged without helm", path: t.TempDir(), managed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PATH", test.path)
+
+			resource := Resource{Chart: &Chart{Path: "demo"}, Managed: &test.managed}
+			resource.load("demo")
+
+			err := resource.validate(settings, "demo")
+			if test.err == "" && err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("validate() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestResourceProcessChart(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity)
+	argsPath, valuesPath := testHelm(t)
+
+	settings := testSettings(t)
+	settings.Directories.Target = "clusters"
+	testChart(t, settings)
+
+	namespace := "team-a"
+	resource := &Resource{
+		Chart:     &Chart{Path: "demo", ReleaseName: "release", IncludeCRDs: true, APIVersions: []string{"example.com/v1"}},
+		Namespace: &namespace,
+	}
+	resource.load("app")
+
+	secrets := &Secrets{
+		keys:       &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+		encryption: []EncryptionRule{defaultEncryptionRule},
+	}
+	values := Values{"Values": Values{"mode": "a"}}
+	err := resource.processChart(settings, values, secrets, &ResourceReport{report: &Report{}}, "dev")
+	if err != nil {
+		t.Fatalf("processChart() error = %v", err)
+	}
+
+	args, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := []string{
+		"template", "release", filepath.Join(settings.pathTemplates(), "demo"),
+		"--namespace", "team-a", "--values", "-",
+		"--include-crds", "--api-versions", "example.com/v1",
+	}
+	if got := strings.Split(strings.TrimSuffix(string(args), "\n"), "\n"); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("helm arguments = %q, want %q", got, wantArgs)
+	}
+
+	chartValues, err := os.ReadFile(valuesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(chartValues) != "mode: a\n" {
+		t.Errorf("helm values = %q, want %q", chartValues, "mode: a\n")
+	}
+
+	renderedPath := resource.pathRendered(settings, "dev")
+	manifests, err := os.ReadFile(filepath.Join(renderedPath, chartManifestsFile))
+	if err != nil {
+		t.Fatalf("manifests not written: %v", err)
+	}
+	if strings.Contains(string(manifests), "hunter2") {
+		t.Errorf("manifests contain the secret:\n%s", manifests)
+	}
+	documents := strings.Split(string(manifests), "\n---\n")
+	if len(documents) != 2 || !strings.Contains(documents[0], "mode: a") {
+		t.Fatalf("manifests =\n%s", manifests)
+	}
+	decrypted, err := decrypt.Data([]byte(documents[1]), "yaml")
+	if err != nil {
+		t.Fatalf("decrypt error = %v", err)
+	}
+	if !strings.Contains(string(decrypted), "password: hunter2") {
+		t.Errorf("decrypted secret does not contain the password:\n%s", decrypted)
+	}
+
+	kustomizationYAML, err := os.ReadFile(filepath.Join(renderedPath, "kustomization.yaml"))
+	if err != nil {
+		t.Fatalf("kustomization not written: %v", err)
+	}
+	kustomization := Kustomization{}
+	err = yaml.Unmarshal(kustomizationYAML, &kustomization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(kustomization.Resources, []string{chartManifestsFile}) {
+		t.Errorf("kustomization resources = %v, want %v", kustomization.Resources, []string{chartManifestsFile})
+	}
+}
+
+func TestChartRenderHelm(t *testing.T) {
+	if _, err := exec.LookPath("helm"); err != nil {
+		t.Skip("helm not installed")
+	}
+
+	settings := testSettings(t)
+	testChart(t, settings)
+
+	chart := &Chart{Path: "demo"}
+	manifests, err := chart.render(settings, Values{"Values": Values{"mode": "a"}}, "release", "team-a")
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	for _, want := range []string{"name: release", "namespace: team-a", "mode: a", "kind: Secret"} {
+		if !strings.Contains(string(manifests), want) {
+			t.Errorf("render() =\n%s\nwant %q", manifests, want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package fkt
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,7 +19,13 @@ type Cluster struct {
 	Managed       *bool                `yaml:"managed"`
 	Values        *Values              `yaml:"values,flow"`
 	Resources     map[string]*Resource `yaml:"resources,flow"`
+	Merge         *Merge               `yaml:"merge"`
+	Flux          *Flux                `yaml:"flux"`
 	AgePublicKey  string               `yaml:"age_public_key"`
+	Sops          *Sops                `yaml:"sops"`
+	Encryption    []EncryptionRule     `yaml:"encryption"`
+	Extends       string               `yaml:"extends"`
+	Secrets       *SecretsConfig       `yaml:"secrets"`
 	path          *string
 }
 
@@ -57,36 +64,74 @@ func (c *Cluster) load(path string) {
 	if c.Values == nil {
 		c.Values = new(Values)
 	}
+
+	for resourceName, resource := range c.Resources {
+		if resource == nil {
+			resource = &Resource{}
+			c.Resources[resourceName] = resource
+		}
+		resource.load(resourceName)
+	}
+	c.assignNamespaces()
+}
+
+func (c *Cluster) resourceValues(config *Config, resource *Resource) Values {
+	clusterMerge := config.Settings.Merge.override(c.Merge)
+	clusterValues := clusterMerge.values(config.Values, *c.Values)
+
+	values := make(Values)
+	values["Cluster"] = c.config()
+	values["Resource"] = resource.config()
+	values["Values"] = clusterMerge.override(resource.Merge).values(clusterValues, resource.Values)
+
+	return values
+}
+
+func (c *Cluster) kustomizationValues(config *Config) Values {
+	values := make(Values)
+	values["Cluster"] = c.config()
+	values["Values"] = config.Settings.Merge.override(c.Merge).values(config.Values, *c.Values)
+
+	return values
 }
 
 func (c *Cluster) pathTargets(settings *Settings) string {
 	return filepath.Join(settings.pathTargets(), *c.path)
 }
 
-func (c *Cluster) process(config *Config) error {
+func (c *Cluster) process(config *Config) (err error) {
 	log.Info("Processing cluster: ", *c.path)
+	report := config.report.cluster(*c.path)
+	defer func() { report.done(err) }()
+
 	if c.Values == nil {
 		log.Trace("Cluster ", *c.path, " has no values")
 		c.Values = &Values{}
 	}
 
+	clusterMerge := config.Settings.Merge.override(c.Merge)
 	secrets := Secrets{
-		ageKey:       c.AgePublicKey,
-		lastModified: nil,
+		keys: c.sops(),
 	}
-	if config.Secrets.SecretsFile != "" && secrets.ageKey != "" {
-		err := secrets.read(filepath.Join(config.Settings.Directories.baseDirectory, config.Secrets.SecretsFile))
-		if err != nil {
-			return err
+	if secrets.keys != nil {
+		for _, secretsConfig := range []*SecretsConfig{&config.Secrets, c.Secrets} {
+			if secretsConfig.file() == "" {
+				continue
+			}
+			secrets, err = secrets.layer(config.secrets, secretsConfig.path(config.Settings), clusterMerge)
+			if err != nil {
+				return fmt.Errorf("cannot read secrets: %s; %w", secretsConfig.file(), err)
+			}
 		}
 	}
 
-	err := utils.MkDir(c.pathTargets(config.Settings), config.Settings.DryRun)
+	err = utils.MkDir(c.pathTargets(config.Settings), config.Settings.DryRun)
 	if err != nil {
 		return err
 	}
 
 	var processedResources []string
+	var templateErrors TemplateErrors
 
 	log.Info("Processing resources")
 	for resourceName, resource := range c.Resources {
@@ -100,28 +145,57 @@ func (c *Cluster) process(config *Config) error {
 
 		if !*resource.Managed {
 			log.Info("Skipping unmanaged resource: ", resourceName)
+			report.resource(resourceName, ResourceUnmanaged)
+			continue
+		}
+
+		if !config.selector.resource(resourceName) {
+			log.Info("Skipping unselected resource: ", resourceName)
+			report.resource(resourceName, ResourceUnselected)
 			continue
 		}
 
-		log.Info("Processing resource template: ", *resource.Template, ", into ", *c.path, "/", resourceName)
+		log.Info("Processing resource template: ", resource.source(), ", into ", *c.path, "/", resourceName)
 
-		values := make(Values)
-		values["Cluster"] = c.config()
-		values["Resource"] = resource.config()
-		values["Values"] = ProcessValues(&config.Values, c.Values, &resource.Values)
+		values := c.resourceValues(config, resource)
 		log.Trace("Values: ", values)
 
+		resourceSecrets := secrets
+		if resource.Secrets.file() != "" && secrets.keys != nil {
+			resourceSecrets, err = secrets.layer(config.secrets, resource.Secrets.path(config.Settings), clusterMerge.override(resource.Merge))
+			if err != nil {
+				return fmt.Errorf("cannot read secrets for resource: %s; %w", resource.Name, err)
+			}
+		}
+		resourceSecrets.encryption = encryptionRules(resource.Encryption, c.Encryption, config.Settings.Encryption)
+
 		log.Info("Processing ", resource.Name)
-		err := resource.process(config.Settings, values, &secrets, *c.path)
+		resourceReport := report.resource(resourceName, ResourceRendered)
+		resourceReport.Template = resource.source()
+		err := resource.process(config.Settings, values, &resourceSecrets, resourceReport, *c.path)
+		resourceReport.done()
+		var resourceTemplateErrors TemplateErrors
+		if errors.As(err, &resourceTemplateErrors) {
+			templateErrors = append(templateErrors, resourceTemplateErrors...)
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("cannot process resource: %s; %w", resource.Name, err)
 		}
 	}
+	if len(templateErrors) > 0 {
+		return templateErrors
+	}
+
+	fluxResources, err := c.generateFlux(config.Settings, report)
+	if err != nil {
+		return fmt.Errorf("cannot generate flux kustomizations: %w", err)
+	}
 
 	if *c.Managed {
 		var removableResourcePaths []string
 
-		resourceEntries, err := os.ReadDir(c.pathTargets(config.Settings))
+		resourceEntries, err := utils.ReadDir(c.pathTargets(config.Settings))
 		if err != nil {
 			return fmt.Errorf("cannot get listing of resources in cluster path: %s; %w", c.pathTargets(config.Settings), err)
 		}
@@ -135,14 +209,7 @@ func (c *Cluster) process(config *Config) error {
 
 			log.Debug("Checking resource ", resourceEntryName, ", path ", utils.RelWD(resourcePath))
 
-			exists, err := utils.IsDir(resourcePath)
-			if config.Settings.DryRun {
-				if exists {
-					return fmt.Errorf("%s exists when it should not", resourcePath)
-				} else {
-					return nil
-				}
-			}
+			_, err := utils.IsDir(resourcePath)
 			if !os.IsExist(err) {
 				if !slices.Contains(processedResources, resourceEntryName) {
 					log.Info("Adding ", resourcePath)
@@ -154,26 +221,38 @@ func (c *Cluster) process(config *Config) error {
 		log.Debug("Removing unnecessary resource target paths, ", removableResourcePaths)
 		for _, removableResourcePath := range removableResourcePaths {
 			log.Trace("Removing path: ", utils.RelWD(removableResourcePath))
-			if config.Settings.DryRun {
-				if utils.IsExist(removableResourcePath) {
-					return fmt.Errorf("dry-run, %s should not exist", removableResourcePath)
-				}
-			}
-			err := os.RemoveAll(removableResourcePath)
+			err := utils.RemoveAll(removableResourcePath, config.Settings.DryRun)
 			if err != nil {
 				return fmt.Errorf("could not remove unnecessary resource target path: %s; %w", removableResourcePath, err)
 			}
+			report.file(removableResourcePath, FileRemoved)
 		}
 
 		log.Debug("Generating kustomization for cluster: ", *c.path)
-		kustomization := &Kustomization{
-			Kind:              "Kustomization",
-			APIVersion:        "kustomize.config.k8s.io/v1beta1",
-			CommonAnnotations: c.Kustomization.CommonAnnotations,
-			Patches:           c.Kustomization.Patches,
+		kustomization, err := c.Kustomization.render("kustomization", c.kustomizationValues(config), config.Settings)
+		var templateError *TemplateError
+		if errors.As(err, &templateError) {
+			templateError.Cluster = *c.path
+			return TemplateErrors{templateError}
+		}
+		if err != nil {
+			return fmt.Errorf("cannot template kustomization: %w", err)
+		}
+		kustomization.Kind = "Kustomization"
+		kustomization.APIVersion = "kustomize.config.k8s.io/v1beta1"
+		kustomization.Resources = nil
+
+		kustomizationResources := []string{}
+		for _, resourceName := range maps.Keys(c.Resources) {
+			if !slices.Contains(fluxResources, resourceName) {
+				kustomizationResources = append(kustomizationResources, resourceName)
+			}
+		}
+		if len(fluxResources) > 0 {
+			kustomizationResources = append(kustomizationResources, fluxFile)
 		}
 
-		err = kustomization.generate(c.pathTargets(config.Settings), maps.Keys(c.Resources), config.Settings.DryRun)
+		err = kustomization.generate(c.pathTargets(config.Settings), kustomizationResources, config.Settings.DryRun, report)
 		if err != nil {
 			return fmt.Errorf("cannot generate kustomization: %w", err)
 		}
@@ -185,6 +264,26 @@ func (c *Cluster) process(config *Config) error {
 func (c *Cluster) validate(config *Config) error {
 	log.Info("Validating cluster: ", *c.path)
 
+	err := c.validateFlux()
+	if err != nil {
+		return err
+	}
+
+	err = c.validateSops()
+	if err != nil {
+		return err
+	}
+
+	err = validateEncryptionRules(c.Encryption)
+	if err != nil {
+		return fmt.Errorf("cluster %s: %w", *c.path, err)
+	}
+
+	err = c.Secrets.validate(config.Settings, c.sops())
+	if err != nil {
+		return fmt.Errorf("cluster %s: %w", *c.path, err)
+	}
+
 	for name, resource := range c.Resources {
 		log.Debug("Validating resource: ", name)
 
@@ -197,7 +296,17 @@ func (c *Cluster) validate(config *Config) error {
 		if err != nil {
 			return err
 		}
+
+		err = resource.Secrets.validate(config.Settings, c.sops())
+		if err != nil {
+			return fmt.Errorf("resource %s/%s: %w", *c.path, name, err)
+		}
+
+		err = validateEncryptionRules(resource.Encryption)
+		if err != nil {
+			return fmt.Errorf("resource %s/%s: %w", *c.path, name, err)
+		}
 	}
 
-	return nil
+	return c.validateNamespaces()
 }
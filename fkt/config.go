@@ -1,52 +1,192 @@
 package fkt
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
+
+	utils "github.com/clingclangclick/fkt/utils"
 )
 
 type Config struct {
+	Include  []string            `yaml:"include"`
+	Anchors  interface{}         `yaml:"anchors"`
 	Settings *Settings           `yaml:"settings"`
 	Values   Values              `yaml:"values,flow"`
+	Profiles map[string]*Cluster `yaml:"profiles"`
 	Clusters map[string]*Cluster `yaml:"clusters"`
-	Secrets  struct {
-		SecretsFile string `yaml:"file"`
-		secrets     Secrets
-	} `yaml:"secrets"`
+	Secrets  SecretsConfig       `yaml:"secrets"`
+	selector *selector
+	report   *Report
+	secrets  *secretsCache
+}
+
+type configLoader struct {
+	loaded       map[string]struct{}
+	clusterFiles map[string]string
+	profileFiles map[string]string
+	values       []Values
+	settingsFile string
+	secretsFile  string
 }
 
-func LoadConfig(configurationFile string) (*Config, error) {
-	config := Config{}
+// Configuration files are loaded in order, each followed by its includes.
+// Values of later files are merged over earlier ones, clusters and profiles
+// must be unique across files, settings and the secrets file may only be set
+// once.
+func LoadConfig(configurationFiles ...string) (*Config, error) {
+	config := Config{
+		Profiles: map[string]*Cluster{},
+		Clusters: map[string]*Cluster{},
+	}
+	loader := configLoader{
+		loaded:       map[string]struct{}{},
+		clusterFiles: map[string]string{},
+		profileFiles: map[string]string{},
+	}
+
+	for _, configurationFile := range configurationFiles {
+		err := loader.load(&config, configurationFile)
+		if err != nil {
+			return &config, err
+		}
+	}
+
+	if config.Settings == nil {
+		config.Settings = &Settings{}
+	}
 
-	configurationFileInfo, err := os.Stat(configurationFile)
+	merge := mergeDefaults.override(&config.Settings.Merge)
+	config.Values = Values{}
+	for _, values := range loader.values {
+		config.Values = merge.values(config.Values, values)
+	}
+	config.Include = nil
+
+	err := config.resolveProfiles()
 	if err != nil {
 		return &config, err
 	}
 
+	return &config, nil
+}
+
+func (loader *configLoader) load(config *Config, configurationFile string) error {
+	absoluteFile, err := filepath.Abs(configurationFile)
+	if err != nil {
+		return err
+	}
+	if _, ok := loader.loaded[absoluteFile]; ok {
+		log.Debug("Configuration file already loaded: ", configurationFile)
+		return nil
+	}
+	loader.loaded[absoluteFile] = struct{}{}
+
 	configurationBytes, err := os.ReadFile(configurationFile)
 	if err != nil {
-		return &config, err
+		return err
 	}
-	err = yaml.Unmarshal(configurationBytes, &config)
+
+	var node yaml.Node
+	err = yaml.Unmarshal(configurationBytes, &node)
 	if err != nil {
-		return &config, err
+		return fmt.Errorf("%s: %w", configurationFile, err)
 	}
 
-	if config.Settings == nil {
-		config.Settings = &Settings{}
+	err = validateSchema(configurationFile, &node)
+	if err != nil {
+		return err
+	}
+
+	part := Config{}
+	if node.Kind != 0 {
+		err = node.Decode(&part)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configurationFile, err)
+		}
+	}
+
+	if part.Settings != nil {
+		if loader.settingsFile != "" {
+			return fmt.Errorf("settings in %s already set in %s", configurationFile, loader.settingsFile)
+		}
+		loader.settingsFile = configurationFile
+		config.Settings = part.Settings
+	}
+
+	if part.Secrets.SecretsFile != "" {
+		if loader.secretsFile != "" && config.Secrets.SecretsFile != part.Secrets.SecretsFile {
+			return fmt.Errorf("secrets file in %s already set in %s", configurationFile, loader.secretsFile)
+		}
+		loader.secretsFile = configurationFile
+		config.Secrets.SecretsFile = part.Secrets.SecretsFile
 	}
-	config.Settings.configFileModifiedTime = configurationFileInfo.ModTime().UTC()
 
-	return &config, err
+	for profileName, profile := range part.Profiles {
+		if profileFile, ok := loader.profileFiles[profileName]; ok {
+			return fmt.Errorf("profile %s in %s already defined in %s", profileName, configurationFile, profileFile)
+		}
+		loader.profileFiles[profileName] = configurationFile
+		config.Profiles[profileName] = profile
+	}
+
+	for clusterPath, cluster := range part.Clusters {
+		if clusterFile, ok := loader.clusterFiles[clusterPath]; ok {
+			return fmt.Errorf("cluster %s in %s already defined in %s", clusterPath, configurationFile, clusterFile)
+		}
+		loader.clusterFiles[clusterPath] = configurationFile
+		config.Clusters[clusterPath] = cluster
+	}
+
+	// Included files are loaded first so the including file's values take
+	// precedence.
+	for _, include := range part.Include {
+		pattern := include
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configurationFile), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include in %s: %s; %w", configurationFile, include, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("include in %s matches no files: %s", configurationFile, include)
+		}
+		slices.Sort(matches)
+
+		for _, match := range matches {
+			log.Debug("Including configuration file: ", match)
+			err := loader.load(config, match)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if part.Values != nil {
+		loader.values = append(loader.values, part.Values)
+	}
+
+	return nil
 }
 
 func (config *Config) Process() error {
 	log.Info("Processing configuration...")
+	config.report = newReport(config.Settings)
+	config.secrets = newSecretsCache()
 
+	selected := 0
+	var clusterErrorsMutex sync.Mutex
+	var clusterErrors []error
 	var eg = new(errgroup.Group)
 	for path, cluster := range config.Clusters {
 		if cluster == nil {
@@ -56,14 +196,56 @@ func (config *Config) Process() error {
 		c := cluster
 		c.load(path)
 
+		if !config.selector.cluster(path, c) {
+			log.Info("Skipping unselected cluster: ", path)
+			continue
+		}
+		selected++
+
 		func(c *Cluster) {
 			eg.Go(func() error {
-				return c.process(config)
+				err := c.process(config)
+				if err != nil {
+					// Secrets files shared by clusters fail once for the run.
+					var decryptErr *secretsDecryptError
+					if errors.As(err, &decryptErr) {
+						err = decryptErr
+					}
+
+					clusterErrorsMutex.Lock()
+					if !slices.Contains(clusterErrors, err) {
+						clusterErrors = append(clusterErrors, err)
+					}
+					clusterErrorsMutex.Unlock()
+				}
+				return nil
 			})
 		}(c)
 	}
-	if err := eg.Wait(); err != nil {
-		return fmt.Errorf("processing failed: %w", err)
+	_ = eg.Wait()
+	if len(clusterErrors) > 0 {
+		slices.SortFunc(clusterErrors, func(a, b error) int {
+			return strings.Compare(a.Error(), b.Error())
+		})
+		return fmt.Errorf("processing failed: %w", errors.Join(clusterErrors...))
+	}
+
+	if config.Settings.Validation.Manifests {
+		err := config.validateManifests()
+		if err != nil {
+			return fmt.Errorf("manifest validation failed: %w", err)
+		}
+	}
+
+	if config.Settings.Validation.Build {
+		err := config.buildClusters()
+		if err != nil {
+			return fmt.Errorf("kustomize build failed: %w", err)
+		}
+	}
+
+	if selected == 0 {
+		config.report.warn("No clusters selected")
 	}
 
 	return nil
@@ -93,3 +275,75 @@ func (config *Config) Validate() error {
 
 	return nil
 }
+
+func (config *Config) Templates() ([]string, error) {
+	entries, err := os.ReadDir(config.Settings.pathTemplates())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list templates directory: %w", err)
+	}
+
+	var templates []string
+	for _, entry := range entries {
+		if entry.IsDir() && utils.ContainsKustomization(filepath.Join(config.Settings.pathTemplates(), entry.Name())) {
+			templates = append(templates, entry.Name())
+		}
+	}
+
+	return templates, nil
+}
+
+func (config *Config) Explain(clusterPath string, resourceName string) (Values, error) {
+	cluster, ok := config.Clusters[clusterPath]
+	if !ok {
+		return nil, fmt.Errorf("cluster not found: %s", clusterPath)
+	}
+	if cluster == nil {
+		cluster = &Cluster{}
+		config.Clusters[clusterPath] = cluster
+	}
+	cluster.load(clusterPath)
+
+	resource, ok := cluster.Resources[resourceName]
+	if !ok {
+		return nil, fmt.Errorf("resource not found: %s/%s", clusterPath, resourceName)
+	}
+
+	explanation := Values{
+		"cluster":  clusterPath,
+		"resource": resourceName,
+		"managed":  *cluster.Managed && *resource.Managed,
+		"target":   filepath.Join(config.Settings.Directories.Target, clusterPath, resourceName),
+		"values":   cluster.resourceValues(config, resource),
+	}
+	if resource.Chart != nil {
+		explanation["chart"] = filepath.Join(config.Settings.Directories.Templates, resource.Chart.Path)
+	} else if resource.Helm != nil {
+		explanation["helm"] = resource.Helm.Chart
+	} else {
+		explanation["template"] = filepath.Join(config.Settings.Directories.Templates, *resource.Template)
+	}
+
+	if resource.overlaid() {
+		kustomization, err := resource.overlay(config.Settings, cluster.resourceValues(config, resource), clusterPath)
+		if err != nil {
+			return nil, err
+		}
+		explanation["kustomization"] = kustomization
+	}
+
+	var secretsFiles []string
+	for _, secretsConfig := range []*SecretsConfig{&config.Secrets, cluster.Secrets, resource.Secrets} {
+		if secretsConfig.file() != "" && cluster.sops() != nil {
+			secretsFiles = append(secretsFiles, secretsConfig.file())
+		}
+	}
+	if len(secretsFiles) > 0 {
+		explanation["secrets"] = secretsFiles
+	}
+
+	if cluster.Flux.override(resource.Flux).enabled() {
+		explanation["flux"] = cluster.fluxKustomization(config.Settings, resourceName)
+	}
+
+	return explanation, nil
+}
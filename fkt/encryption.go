@@ -0,0 +1,144 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Selects rendered documents to encrypt and the values SOPS encrypts in them.
+// Only one of encrypted_regex, encrypted_suffix and unencrypted_regex may be
+// set, without any data and stringData are encrypted.
+type EncryptionRule struct {
+	Match            EncryptionMatch `yaml:"match"`
+	EncryptedRegex   string          `yaml:"encrypted_regex"`
+	EncryptedSuffix  string          `yaml:"encrypted_suffix"`
+	UnencryptedRegex string          `yaml:"unencrypted_regex"`
+}
+
+// Kind, apiVersion, name and annotation values are path.Match patterns, every
+// set field must match.
+type EncryptionMatch struct {
+	Kind        string            `yaml:"kind"`
+	APIVersion  string            `yaml:"apiVersion"`
+	Name        string            `yaml:"name"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+const defaultEncryptedRegex = "^(data|stringData)$"
+
+var defaultEncryptionRule = EncryptionRule{
+	Match: EncryptionMatch{
+		Kind: "Secret",
+	},
+	EncryptedRegex: defaultEncryptedRegex,
+}
+
+type encryptionObject struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+}
+
+// Rules of the resource, cluster and settings in that order followed by the
+// default rule for Secrets, the first matching rule applies.
+func encryptionRules(layers ...[]EncryptionRule) []EncryptionRule {
+	var rules []EncryptionRule
+	for _, layer := range layers {
+		rules = append(rules, layer...)
+	}
+
+	return append(rules, defaultEncryptionRule)
+}
+
+func validateEncryptionRules(rules []EncryptionRule) error {
+	for index, rule := range rules {
+		err := rule.validate()
+		if err != nil {
+			return fmt.Errorf("encryption rule %d: %w", index, err)
+		}
+	}
+
+	return nil
+}
+
+func (rule EncryptionRule) validate() error {
+	set := 0
+	for _, expression := range []string{rule.EncryptedRegex, rule.EncryptedSuffix, rule.UnencryptedRegex} {
+		if expression != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("encrypted_regex, encrypted_suffix and unencrypted_regex are mutually exclusive")
+	}
+
+	for _, expression := range []string{rule.EncryptedRegex, rule.UnencryptedRegex} {
+		if _, err := regexp.Compile(expression); err != nil {
+			return fmt.Errorf("invalid regex: %s; %w", expression, err)
+		}
+	}
+
+	patterns := []string{rule.Match.Kind, rule.Match.APIVersion, rule.Match.Name}
+	for _, pattern := range rule.Match.Annotations {
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid match pattern: %s; %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func (rule EncryptionRule) matches(object *encryptionObject) bool {
+	match := func(pattern, value string) bool {
+		if pattern == "" {
+			return true
+		}
+		ok, _ := path.Match(pattern, value)
+		return ok
+	}
+
+	if !match(rule.Match.Kind, object.Kind) ||
+		!match(rule.Match.APIVersion, object.APIVersion) ||
+		!match(rule.Match.Name, object.Metadata.Name) {
+		return false
+	}
+
+	for key, pattern := range rule.Match.Annotations {
+		value, ok := object.Metadata.Annotations[key]
+		if !ok || !match(pattern, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the first rule matching the rendered document, nil if none match.
+func matchEncryptionRule(rules []EncryptionRule, document []byte) (*EncryptionRule, error) {
+	object := &encryptionObject{}
+	err := yaml.Unmarshal(document, object)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if rule.matches(object) {
+			if rule.EncryptedRegex == "" && rule.EncryptedSuffix == "" && rule.UnencryptedRegex == "" {
+				rule.EncryptedRegex = defaultEncryptedRegex
+			}
+			return &rule, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,406 @@
+package fkt
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	log "github.com/sirupsen/logrus"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+const fluxFile = "flux.yaml"
+
+var fluxDefaults = map[string]string{
+	"namespace":        "flux-system",
+	"interval":         "10m",
+	"source_kind":      "GitRepository",
+	"source_name":      "flux-system",
+	"source_interval":  "1m",
+	"source_reference": "main",
+}
+
+type Flux struct {
+	Enabled      *bool             `yaml:"enabled"`
+	Namespace    string            `yaml:"namespace"`
+	Interval     string            `yaml:"interval"`
+	Prune        *bool             `yaml:"prune"`
+	DependsOn    []string          `yaml:"dependsOn"`
+	Path         string            `yaml:"path"`
+	SourceRef    *FluxSourceRef    `yaml:"sourceRef"`
+	Source       *FluxSource       `yaml:"source"`
+	Decryption   *FluxDecryption   `yaml:"decryption"`
+	HealthChecks []FluxHealthCheck `yaml:"healthChecks"`
+	Timeout      string            `yaml:"timeout"`
+}
+
+type FluxSourceRef struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type FluxSource struct {
+	Name      string              `yaml:"name"`
+	URL       string              `yaml:"url"`
+	Interval  string              `yaml:"interval"`
+	Ref       *FluxGitReference   `yaml:"ref"`
+	SecretRef *FluxLocalObjectRef `yaml:"secretRef"`
+}
+
+type FluxGitReference struct {
+	Branch string `yaml:"branch,omitempty"`
+	Tag    string `yaml:"tag,omitempty"`
+	SemVer string `yaml:"semver,omitempty"`
+	Name   string `yaml:"name,omitempty"`
+	Commit string `yaml:"commit,omitempty"`
+}
+
+type FluxDecryption struct {
+	Provider  string              `yaml:"provider"`
+	SecretRef *FluxLocalObjectRef `yaml:"secretRef,omitempty"`
+}
+
+type FluxLocalObjectRef struct {
+	Name string `yaml:"name"`
+}
+
+type FluxHealthCheck struct {
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	Namespace  string `yaml:"namespace,omitempty"`
+}
+
+type fluxMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type fluxKustomization struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   fluxMetadata `yaml:"metadata"`
+	Spec       struct {
+		Interval     string               `yaml:"interval"`
+		Path         string               `yaml:"path"`
+		Prune        bool                 `yaml:"prune"`
+		SourceRef    FluxSourceRef        `yaml:"sourceRef"`
+		DependsOn    []FluxLocalObjectRef `yaml:"dependsOn,omitempty"`
+		Decryption   *FluxDecryption      `yaml:"decryption,omitempty"`
+		HealthChecks []FluxHealthCheck    `yaml:"healthChecks,omitempty"`
+		Timeout      string               `yaml:"timeout,omitempty"`
+	} `yaml:"spec"`
+}
+
+type fluxGitRepository struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   fluxMetadata `yaml:"metadata"`
+	Spec       struct {
+		Interval  string              `yaml:"interval"`
+		URL       string              `yaml:"url"`
+		Ref       *FluxGitReference   `yaml:"ref"`
+		SecretRef *FluxLocalObjectRef `yaml:"secretRef,omitempty"`
+	} `yaml:"spec"`
+}
+
+// Fields set on override take precedence, unset fields are inherited.
+func (f *Flux) override(override *Flux) *Flux {
+	if f == nil && override == nil {
+		return nil
+	}
+
+	flux := Flux{}
+	if f != nil {
+		flux = *f
+	}
+	if override == nil {
+		return &flux
+	}
+
+	if override.Enabled != nil {
+		flux.Enabled = override.Enabled
+	}
+	if override.Namespace != "" {
+		flux.Namespace = override.Namespace
+	}
+	if override.Interval != "" {
+		flux.Interval = override.Interval
+	}
+	if override.Prune != nil {
+		flux.Prune = override.Prune
+	}
+	if override.DependsOn != nil {
+		flux.DependsOn = override.DependsOn
+	}
+	if override.Path != "" {
+		flux.Path = override.Path
+	}
+	if override.SourceRef != nil {
+		flux.SourceRef = override.SourceRef
+	}
+	if override.Source != nil {
+		flux.Source = override.Source
+	}
+	if override.Decryption != nil {
+		flux.Decryption = override.Decryption
+	}
+	if override.HealthChecks != nil {
+		flux.HealthChecks = override.HealthChecks
+	}
+	if override.Timeout != "" {
+		flux.Timeout = override.Timeout
+	}
+
+	return &flux
+}
+
+func (f *Flux) enabled() bool {
+	return f != nil && (f.Enabled == nil || *f.Enabled)
+}
+
+func (f *Flux) namespace() string {
+	if f.Namespace != "" {
+		return f.Namespace
+	}
+	return fluxDefaults["namespace"]
+}
+
+func (f *Flux) validate(name string) error {
+	for field, duration := range map[string]string{
+		"interval": f.Interval,
+		"timeout":  f.Timeout,
+	} {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return fmt.Errorf("flux %s for %s is not a duration: %w", field, name, err)
+		}
+	}
+
+	if f.Source != nil && f.Source.URL == "" {
+		return fmt.Errorf("flux source for %s has no url", name)
+	}
+
+	return nil
+}
+
+func (source *FluxSource) gitRepository(clusterPath string, namespace string) fluxGitRepository {
+	gitRepository := fluxGitRepository{
+		APIVersion: "source.toolkit.fluxcd.io/v1",
+		Kind:       "GitRepository",
+		Metadata: fluxMetadata{
+			Name:      source.name(clusterPath),
+			Namespace: namespace,
+		},
+	}
+
+	gitRepository.Spec.Interval = source.Interval
+	if gitRepository.Spec.Interval == "" {
+		gitRepository.Spec.Interval = fluxDefaults["source_interval"]
+	}
+	gitRepository.Spec.URL = source.URL
+	gitRepository.Spec.Ref = source.Ref
+	if gitRepository.Spec.Ref == nil {
+		gitRepository.Spec.Ref = &FluxGitReference{Branch: fluxDefaults["source_reference"]}
+	}
+	gitRepository.Spec.SecretRef = source.SecretRef
+
+	return gitRepository
+}
+
+func (source *FluxSource) name(clusterPath string) string {
+	if source.Name != "" {
+		return source.Name
+	}
+	_, name := filepath.Split(clusterPath)
+	return name
+}
+
+func (f *Flux) kustomization(settings *Settings, clusterPath string, resourceName string) fluxKustomization {
+	kustomization := fluxKustomization{
+		APIVersion: "kustomize.toolkit.fluxcd.io/v1",
+		Kind:       "Kustomization",
+		Metadata: fluxMetadata{
+			Name:      resourceName,
+			Namespace: f.namespace(),
+		},
+	}
+
+	kustomization.Spec.Interval = f.Interval
+	if kustomization.Spec.Interval == "" {
+		kustomization.Spec.Interval = fluxDefaults["interval"]
+	}
+
+	kustomization.Spec.Path = f.Path
+	if kustomization.Spec.Path == "" {
+		kustomization.Spec.Path = "./" + filepath.ToSlash(filepath.Join(settings.Directories.Target, clusterPath, resourceName))
+	}
+
+	kustomization.Spec.Prune = f.Prune == nil || *f.Prune
+
+	switch {
+	case f.SourceRef != nil:
+		kustomization.Spec.SourceRef = *f.SourceRef
+	case f.Source != nil:
+		kustomization.Spec.SourceRef = FluxSourceRef{
+			Kind: fluxDefaults["source_kind"],
+			Name: f.Source.name(clusterPath),
+		}
+	default:
+		kustomization.Spec.SourceRef = FluxSourceRef{
+			Kind: fluxDefaults["source_kind"],
+			Name: fluxDefaults["source_name"],
+		}
+	}
+
+	for _, dependency := range f.DependsOn {
+		if dependency == resourceName {
+			continue
+		}
+		kustomization.Spec.DependsOn = append(kustomization.Spec.DependsOn, FluxLocalObjectRef{Name: dependency})
+	}
+	kustomization.Spec.Decryption = f.Decryption
+	kustomization.Spec.HealthChecks = f.HealthChecks
+	kustomization.Spec.Timeout = f.Timeout
+
+	return kustomization
+}
+
+// Flux Kustomization of a resource, depending on the resource writing its
+// namespace when that is applied by Flux.
+func (c *Cluster) fluxKustomization(settings *Settings, resourceName string) fluxKustomization {
+	resource := c.Resources[resourceName]
+	flux := c.Flux.override(resource.Flux)
+	kustomization := flux.kustomization(settings, *c.path, resourceName)
+
+	writer := resource.namespaceWriter
+	if writer != "" && c.Flux.override(c.Resources[writer].Flux).enabled() && !slices.Contains(flux.DependsOn, writer) {
+		kustomization.Spec.DependsOn = append(kustomization.Spec.DependsOn, FluxLocalObjectRef{Name: writer})
+	}
+
+	return kustomization
+}
+
+// Sorted names of the managed resources applied by Flux Kustomizations.
+func (c *Cluster) fluxResources() []string {
+	var fluxResources []string
+	for resourceName, resource := range c.Resources {
+		if *resource.Managed && c.Flux.override(resource.Flux).enabled() {
+			fluxResources = append(fluxResources, resourceName)
+		}
+	}
+	slices.Sort(fluxResources)
+
+	return fluxResources
+}
+
+// Returns the names of resources applied by Flux Kustomizations in the
+// cluster flux file, the file is removed when there are none.
+func (c *Cluster) generateFlux(settings *Settings, report *ClusterReport) ([]string, error) {
+	fluxResources := c.fluxResources()
+	var kustomizations []interface{}
+	gitRepositories := map[string]fluxGitRepository{}
+
+	addSource := func(flux *Flux) {
+		if flux.Source == nil {
+			return
+		}
+		name := flux.Source.name(*c.path)
+		if _, ok := gitRepositories[name]; !ok {
+			gitRepositories[name] = flux.Source.gitRepository(*c.path, flux.namespace())
+		}
+	}
+
+	if c.Flux.enabled() {
+		addSource(c.Flux)
+	}
+
+	for _, resourceName := range fluxResources {
+		flux := c.Flux.override(c.Resources[resourceName].Flux)
+
+		log.Debug("Generating flux kustomization for resource: ", resourceName)
+		addSource(flux)
+		kustomizations = append(kustomizations, c.fluxKustomization(settings, resourceName))
+	}
+
+	fluxPath := filepath.Join(c.pathTargets(settings), fluxFile)
+	if len(fluxResources) == 0 {
+		if utils.IsExist(fluxPath) {
+			log.Debug("Removing flux file: ", utils.RelWD(fluxPath))
+			report.file(fluxPath, FileRemoved)
+			return nil, utils.RemoveAll(fluxPath, settings.DryRun)
+		}
+		return nil, nil
+	}
+
+	var documents []interface{}
+	gitRepositoryNames := make([]string, 0, len(gitRepositories))
+	for name := range gitRepositories {
+		gitRepositoryNames = append(gitRepositoryNames, name)
+	}
+	slices.Sort(gitRepositoryNames)
+	for _, name := range gitRepositoryNames {
+		documents = append(documents, gitRepositories[name])
+	}
+	documents = append(documents, kustomizations...)
+
+	var yamlDocuments []string
+	for _, document := range documents {
+		documentYAML, err := yaml.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal flux document: %w", err)
+		}
+		yamlDocuments = append(yamlDocuments, string(documentYAML))
+	}
+
+	log.Info("Generating flux kustomizations for cluster: ", *c.path)
+	action, err := writeFile(fluxPath, []byte(strings.Join(yamlDocuments, "---\n")), settings.DryRun)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write flux file: %w", err)
+	}
+	report.file(fluxPath, action)
+
+	return fluxResources, nil
+}
+
+func (c *Cluster) validateFlux() error {
+	if c.Flux != nil {
+		if err := c.Flux.validate(*c.path); err != nil {
+			return err
+		}
+	}
+
+	for resourceName, resource := range c.Resources {
+		flux := c.Flux.override(resource.Flux)
+		if !flux.enabled() {
+			continue
+		}
+
+		if err := flux.validate(*c.path + "/" + resourceName); err != nil {
+			return err
+		}
+
+		for _, dependency := range flux.DependsOn {
+			if dependency == resourceName {
+				continue
+			}
+			dependencyResource, ok := c.Resources[dependency]
+			if !ok {
+				return fmt.Errorf("flux dependency %s of %s/%s is not a cluster resource", dependency, *c.path, resourceName)
+			}
+			if !*dependencyResource.Managed || !c.Flux.override(dependencyResource.Flux).enabled() {
+				return fmt.Errorf("flux dependency %s of %s/%s is not applied by flux", dependency, *c.path, resourceName)
+			}
+		}
+	}
+
+	return nil
+}
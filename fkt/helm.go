@@ -0,0 +1,226 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const helmReleaseFile = "helmrelease.yaml"
+
+var helmDefaults = map[string]string{
+	"interval":            "10m",
+	"repository_interval": "1h",
+}
+
+// Chart released by the Flux helm-controller, written as a HelmRelease and
+// its HelmRepository. Fields follow the HelmRelease spec.
+type Helm struct {
+	Chart       string          `yaml:"chart"`
+	Version     string          `yaml:"version"`
+	Repository  *HelmRepository `yaml:"repository"`
+	SourceRef   *FluxSourceRef  `yaml:"sourceRef"`
+	Interval    string          `yaml:"interval"`
+	ReleaseName string          `yaml:"releaseName"`
+	Timeout     string          `yaml:"timeout"`
+	DependsOn   []string        `yaml:"dependsOn"`
+	Install     *HelmInstall    `yaml:"install"`
+	Upgrade     *HelmUpgrade    `yaml:"upgrade"`
+}
+
+// Repository type is default or oci, oci:// URLs are oci.
+type HelmRepository struct {
+	Name      string              `yaml:"name"`
+	URL       string              `yaml:"url"`
+	Type      string              `yaml:"type"`
+	Interval  string              `yaml:"interval"`
+	SecretRef *FluxLocalObjectRef `yaml:"secretRef"`
+}
+
+type HelmInstall struct {
+	CreateNamespace bool             `yaml:"createNamespace,omitempty"`
+	CRDs            string           `yaml:"crds,omitempty"`
+	DisableWait     bool             `yaml:"disableWait,omitempty"`
+	Timeout         string           `yaml:"timeout,omitempty"`
+	Remediation     *HelmRemediation `yaml:"remediation,omitempty"`
+}
+
+type HelmUpgrade struct {
+	CRDs          string           `yaml:"crds,omitempty"`
+	DisableWait   bool             `yaml:"disableWait,omitempty"`
+	CleanupOnFail bool             `yaml:"cleanupOnFail,omitempty"`
+	Force         bool             `yaml:"force,omitempty"`
+	Timeout       string           `yaml:"timeout,omitempty"`
+	Remediation   *HelmRemediation `yaml:"remediation,omitempty"`
+}
+
+type HelmRemediation struct {
+	Retries              int    `yaml:"retries,omitempty"`
+	RemediateLastFailure *bool  `yaml:"remediateLastFailure,omitempty"`
+	Strategy             string `yaml:"strategy,omitempty"`
+}
+
+type helmRepository struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   fluxMetadata `yaml:"metadata"`
+	Spec       struct {
+		Interval  string              `yaml:"interval"`
+		URL       string              `yaml:"url"`
+		Type      string              `yaml:"type,omitempty"`
+		SecretRef *FluxLocalObjectRef `yaml:"secretRef,omitempty"`
+	} `yaml:"spec"`
+}
+
+type helmRelease struct {
+	APIVersion string       `yaml:"apiVersion"`
+	Kind       string       `yaml:"kind"`
+	Metadata   fluxMetadata `yaml:"metadata"`
+	Spec       struct {
+		Interval string `yaml:"interval"`
+		Chart    struct {
+			Spec struct {
+				Chart     string        `yaml:"chart"`
+				Version   string        `yaml:"version,omitempty"`
+				SourceRef FluxSourceRef `yaml:"sourceRef"`
+			} `yaml:"spec"`
+		} `yaml:"chart"`
+		ReleaseName string               `yaml:"releaseName,omitempty"`
+		Timeout     string               `yaml:"timeout,omitempty"`
+		DependsOn   []FluxLocalObjectRef `yaml:"dependsOn,omitempty"`
+		Install     *HelmInstall         `yaml:"install,omitempty"`
+		Upgrade     *HelmUpgrade         `yaml:"upgrade,omitempty"`
+		Values      interface{}          `yaml:"values,omitempty"`
+	} `yaml:"spec"`
+}
+
+func (h *Helm) validate() error {
+	if h.Chart == "" {
+		return errors.New("helm chart unset")
+	}
+	if (h.Repository == nil) == (h.SourceRef == nil) {
+		return errors.New("helm requires one of repository or sourceRef")
+	}
+	if h.Repository != nil {
+		if h.Repository.URL == "" {
+			return errors.New("helm repository has no url")
+		}
+		switch h.Repository.Type {
+		case "", "default", "oci":
+		default:
+			return fmt.Errorf("helm repository type must be default or oci: %s", h.Repository.Type)
+		}
+	}
+
+	durations := map[string]string{
+		"interval": h.Interval,
+		"timeout":  h.Timeout,
+	}
+	if h.Repository != nil {
+		durations["repository interval"] = h.Repository.Interval
+	}
+	for field, duration := range durations {
+		if duration == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(duration); err != nil {
+			return fmt.Errorf("helm %s is not a duration: %w", field, err)
+		}
+	}
+
+	return nil
+}
+
+func (repository *HelmRepository) name(resourceName string) string {
+	if repository.Name != "" {
+		return repository.Name
+	}
+	return resourceName
+}
+
+func (repository *HelmRepository) helmRepository(resourceName, namespace string) helmRepository {
+	source := helmRepository{
+		APIVersion: "source.toolkit.fluxcd.io/v1",
+		Kind:       "HelmRepository",
+		Metadata: fluxMetadata{
+			Name:      repository.name(resourceName),
+			Namespace: namespace,
+		},
+	}
+
+	source.Spec.Interval = repository.Interval
+	if source.Spec.Interval == "" {
+		source.Spec.Interval = helmDefaults["repository_interval"]
+	}
+	source.Spec.URL = repository.URL
+	source.Spec.Type = repository.Type
+	if source.Spec.Type == "" && strings.HasPrefix(repository.URL, "oci://") {
+		source.Spec.Type = "oci"
+	}
+	source.Spec.SecretRef = repository.SecretRef
+
+	return source
+}
+
+func (h *Helm) helmRelease(resourceName, namespace string, values Values) helmRelease {
+	release := helmRelease{
+		APIVersion: "helm.toolkit.fluxcd.io/v2",
+		Kind:       "HelmRelease",
+		Metadata: fluxMetadata{
+			Name:      resourceName,
+			Namespace: namespace,
+		},
+	}
+
+	release.Spec.Interval = h.Interval
+	if release.Spec.Interval == "" {
+		release.Spec.Interval = helmDefaults["interval"]
+	}
+	release.Spec.Chart.Spec.Chart = h.Chart
+	release.Spec.Chart.Spec.Version = h.Version
+	if h.SourceRef != nil {
+		release.Spec.Chart.Spec.SourceRef = *h.SourceRef
+	} else {
+		release.Spec.Chart.Spec.SourceRef = FluxSourceRef{
+			Kind: "HelmRepository",
+			Name: h.Repository.name(resourceName),
+		}
+	}
+	release.Spec.ReleaseName = h.ReleaseName
+	release.Spec.Timeout = h.Timeout
+	for _, dependency := range h.DependsOn {
+		release.Spec.DependsOn = append(release.Spec.DependsOn, FluxLocalObjectRef{Name: dependency})
+	}
+	release.Spec.Install = h.Install
+	release.Spec.Upgrade = h.Upgrade
+	if len(values) > 0 {
+		release.Spec.Values = values
+	}
+
+	return release
+}
+
+// Writes the HelmRelease, with the merged values of the resource, and its
+// HelmRepository into the resource overlay.
+func (r *Resource) processHelm(settings *Settings, values Values, report *ResourceReport, clusterPath string) error {
+	var documents []interface{}
+	if r.Helm.Repository != nil {
+		documents = append(documents, r.Helm.Repository.helmRepository(r.Name, *r.Namespace))
+	}
+	releaseValues, _ := values["Values"].(Values)
+	documents = append(documents, r.Helm.helmRelease(r.Name, *r.Namespace, releaseValues))
+
+	var yamlDocuments []string
+	for _, document := range documents {
+		documentYAML, err := yaml.Marshal(document)
+		if err != nil {
+			return fmt.Errorf("cannot marshal helm document: %w", err)
+		}
+		yamlDocuments = append(yamlDocuments, string(documentYAML))
+	}
+
+	return r.writeOverlay(settings, clusterPath, helmReleaseFile, yamlDocuments, report)
+}
@@ -0,0 +1,45 @@
+package fkt
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHelmManifestsMatchBundledSchemas(t *testing.T) {
+	schemas, err := loadManifestSchemas(testSettings(t))
+	if err != nil {
+		t.Fatalf("loadManifestSchemas() error = %v", err)
+	}
+
+	h := &Helm{
+		Chart:      "podinfo",
+		Version:    "6.5.4",
+		Repository: &HelmRepository{URL: "oci://ghcr.io/stefanprodan/charts"},
+	}
+
+	manifests := []struct {
+		key    string
+		object interface{}
+	}{
+		{key: "source.toolkit.fluxcd.io/v1/HelmRepository", object: h.Repository.helmRepository("podinfo", "apps")},
+		{key: "helm.toolkit.fluxcd.io/v2/HelmRelease", object: h.helmRelease("podinfo", "apps", Values{"replicaCount": 2})},
+	}
+
+	for _, manifest := range manifests {
+		t.Run(manifest.key, func(t *testing.T) {
+			if _, ok := schemas[manifest.key]; !ok {
+				t.Fatalf("no bundled schema for %s", manifest.key)
+			}
+
+			b, err := yaml.Marshal(manifest.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failures, unvalidated := schemas.validate(b)
+			if len(failures) > 0 || len(unvalidated) > 0 {
+				t.Errorf("validate() = %v, %v", failures, unvalidated)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+
+	sprig "github.com/Masterminds/sprig/v3"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+const helpersDirectory = "_helpers"
+
+type templateHelper struct {
+	name   string
+	source string
+}
+
+// Named templates defined in the .tpl files of the _helpers directory of the
+// templates directory, read once per run.
+func (settings *Settings) templateHelpers() ([]templateHelper, error) {
+	settings.helpersOnce.Do(func() {
+		helpersPath := filepath.Join(settings.pathTemplates(), helpersDirectory)
+		if isDir, _ := utils.IsDir(helpersPath); !isDir {
+			return
+		}
+
+		helperFiles, err := filepath.Glob(filepath.Join(helpersPath, "*.tpl"))
+		if err != nil {
+			settings.helpersErr = err
+			return
+		}
+		slices.Sort(helperFiles)
+
+		for _, helperFile := range helperFiles {
+			log.Debug("Loading template helpers: ", utils.RelWD(helperFile))
+			source, err := os.ReadFile(helperFile)
+			if err != nil {
+				settings.helpersErr = fmt.Errorf("cannot read template helpers: %s; %w", helperFile, err)
+				return
+			}
+			settings.helpers = append(settings.helpers, templateHelper{
+				name:   helperFile,
+				source: string(source),
+			})
+		}
+	})
+
+	return settings.helpers, settings.helpersErr
+}
+
+// Returns a template with sprig, the helper functions and the named templates
+// of the helpers, files are read relative to the directory of name.
+func (settings *Settings) newTemplate(name string) (*template.Template, error) {
+	helpers, err := settings.templateHelpers()
+	if err != nil {
+		return nil, err
+	}
+
+	var t *template.Template
+	t = template.New(name).
+		Delims(settings.Delimiters.Left, settings.Delimiters.Right).
+		Option("missingkey=" + string(settings.Templates.MissingKey)).
+		Funcs(sprig.FuncMap()).
+		Funcs(template.FuncMap{
+			"include": func(templateName string, data interface{}) (string, error) {
+				var b strings.Builder
+				err := t.ExecuteTemplate(&b, templateName, data)
+				return b.String(), err
+			},
+			"tpl": func(text string, data interface{}) (string, error) {
+				tt, err := t.New(name + ":tpl").Parse(text)
+				if err != nil {
+					return "", err
+				}
+				var b strings.Builder
+				err = tt.Execute(&b, data)
+				return b.String(), err
+			},
+			"toYaml":     toYaml,
+			"fromYaml":   fromYaml,
+			"required":   required,
+			"readFile":   func(path string) (string, error) { return settings.readTemplateFile(name, path, false) },
+			"lookupFile": func(path string) (string, error) { return settings.readTemplateFile(name, path, true) },
+		})
+
+	for _, helper := range helpers {
+		_, err := t.New(helper.name).Parse(helper.source)
+		if err != nil {
+			return nil, newTemplateError(helper.name, 0, err)
+		}
+	}
+
+	return t, nil
+}
+
+func toYaml(v interface{}) (string, error) {
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	err := encoder.Encode(v)
+	if err != nil {
+		return "", err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func fromYaml(s string) (Values, error) {
+	values := Values{}
+	err := yaml.Unmarshal([]byte(s), &values)
+	return values, err
+}
+
+// Fails rendering with message when v is nil or empty.
+func required(message string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New(message)
+	}
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice:
+		if value.Len() == 0 {
+			return nil, errors.New(message)
+		}
+	}
+
+	return v, nil
+}
+
+// Reads path relative to the directory of the template file, paths outside the
+// templates directory are rejected. Missing files are empty when optional.
+func (settings *Settings) readTemplateFile(templatePath, path string, optional bool) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(templatePath), path)
+	}
+
+	templatesPath, err := filepath.Abs(settings.pathTemplates())
+	if err != nil {
+		return "", err
+	}
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	relativePath, err := filepath.Rel(templatesPath, absolutePath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file outside of templates directory: %s", path)
+	}
+
+	b, err := os.ReadFile(absolutePath)
+	if optional && errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
@@ -1,8 +1,10 @@
 package fkt
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
+	"slices"
 
 	"gopkg.in/yaml.v3"
 
@@ -11,21 +13,163 @@ import (
 	utils "github.com/clingclangclick/fkt/utils"
 )
 
+// Fields other than the resources, commonAnnotations and patches follow the
+// kustomize spec, they are passed through to the cluster kustomization and
+// templated against the cluster and its values.
 type Kustomization struct {
-	APIVersion        string            `yaml:"apiVersion"`
-	Kind              string            `yaml:"kind"`
-	Resources         []string          `yaml:"resources"`
-	CommonAnnotations map[string]string `yaml:"commonAnnotations"`
-	Patches           []interface{}     `yaml:"patches"`
+	APIVersion            string            `yaml:"apiVersion"`
+	Kind                  string            `yaml:"kind"`
+	Resources             []string          `yaml:"resources"`
+	CommonAnnotations     map[string]string `yaml:"commonAnnotations"`
+	Patches               []interface{}     `yaml:"patches"`
+	Namespace             string            `yaml:"namespace,omitempty"`
+	NamePrefix            string            `yaml:"namePrefix,omitempty"`
+	NameSuffix            string            `yaml:"nameSuffix,omitempty"`
+	CommonLabels          map[string]string `yaml:"commonLabels,omitempty"`
+	Labels                []interface{}     `yaml:"labels,omitempty"`
+	Components            []string          `yaml:"components,omitempty"`
+	Images                []interface{}     `yaml:"images,omitempty"`
+	Replicas              []interface{}     `yaml:"replicas,omitempty"`
+	ConfigMapGenerator    []interface{}     `yaml:"configMapGenerator,omitempty"`
+	SecretGenerator       []interface{}     `yaml:"secretGenerator,omitempty"`
+	PatchesStrategicMerge []interface{}     `yaml:"patchesStrategicMerge,omitempty"`
 }
 
-func (k *Kustomization) generate(path string, resources []string, dryRun bool) error {
+var kustomizationVerbatimFields = []string{"apiVersion", "kind", "resources", "commonAnnotations", "patches"}
+
+// Kustomize fields that are not strings, templated values of these are
+// resolved as YAML so a templated replica count is a number.
+var kustomizationTypedFields = []string{"count", "includeSelectors", "includeTemplates", "disableNameSuffixHash", "immutable"}
+
+// Returns a copy with the passed through fields templated.
+func (k *Kustomization) render(name string, values Values, settings *Settings) (*Kustomization, error) {
+	var node yaml.Node
+	err := node.Encode(k)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode kustomization: %w", err)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if slices.Contains(kustomizationVerbatimFields, node.Content[i].Value) {
+			continue
+		}
+		err = values.templateNode(name+"."+node.Content[i].Value, node.Content[i+1], false, settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rendered := &Kustomization{}
+	err = node.Decode(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode templated kustomization: %w", err)
+	}
+
+	return rendered, nil
+}
+
+func (v *Values) templateNode(name string, node *yaml.Node, typed bool, settings *Settings) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			err := v.templateNode(name, node.Content[i], false, settings)
+			if err != nil {
+				return err
+			}
+			err = v.templateNode(name, node.Content[i+1], slices.Contains(kustomizationTypedFields, node.Content[i].Value), settings)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			err := v.templateNode(name, child, false, settings)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case yaml.ScalarNode:
+	default:
+		return nil
+	}
+	if node.ShortTag() != "!!str" {
+		return nil
+	}
+
+	tpl, err := v.execute(name, node.Value, 0, settings)
+	if err != nil {
+		return err
+	}
+	if tpl.String() == node.Value {
+		return nil
+	}
+	node.Value = tpl.String()
+	if typed {
+		node.Tag = ""
+		node.Style = 0
+	}
+
+	return nil
+}
+
+// Returns a new kustomization with child replacing the namespace and name
+// affixes of k, merging annotations and labels and appending to the lists.
+func (k *Kustomization) inherit(child *Kustomization) *Kustomization {
+	if k == nil && child == nil {
+		return nil
+	}
+
+	inherited := &Kustomization{}
+	for _, kustomization := range []*Kustomization{k, child} {
+		if kustomization == nil {
+			continue
+		}
+		if kustomization.CommonAnnotations != nil && inherited.CommonAnnotations == nil {
+			inherited.CommonAnnotations = map[string]string{}
+		}
+		for key, value := range kustomization.CommonAnnotations {
+			inherited.CommonAnnotations[key] = value
+		}
+		if kustomization.CommonLabels != nil && inherited.CommonLabels == nil {
+			inherited.CommonLabels = map[string]string{}
+		}
+		for key, value := range kustomization.CommonLabels {
+			inherited.CommonLabels[key] = value
+		}
+		if kustomization.Namespace != "" {
+			inherited.Namespace = kustomization.Namespace
+		}
+		if kustomization.NamePrefix != "" {
+			inherited.NamePrefix = kustomization.NamePrefix
+		}
+		if kustomization.NameSuffix != "" {
+			inherited.NameSuffix = kustomization.NameSuffix
+		}
+		inherited.Patches = append(inherited.Patches, kustomization.Patches...)
+		inherited.Labels = append(inherited.Labels, kustomization.Labels...)
+		inherited.Components = append(inherited.Components, kustomization.Components...)
+		inherited.Images = append(inherited.Images, kustomization.Images...)
+		inherited.Replicas = append(inherited.Replicas, kustomization.Replicas...)
+		inherited.ConfigMapGenerator = append(inherited.ConfigMapGenerator, kustomization.ConfigMapGenerator...)
+		inherited.SecretGenerator = append(inherited.SecretGenerator, kustomization.SecretGenerator...)
+		inherited.PatchesStrategicMerge = append(inherited.PatchesStrategicMerge, kustomization.PatchesStrategicMerge...)
+	}
+
+	return inherited
+}
+
+func (k *Kustomization) generate(path string, resources []string, dryRun bool, report *ClusterReport) error {
+	slices.Sort(resources)
 	for _, resourceName := range resources {
 		resourcePath := filepath.Join(path, resourceName)
 		if utils.ContainsKustomization(resourcePath) {
 			k.Resources = append(k.Resources, resourceName)
+		} else if isFile, _ := utils.IsFile(resourcePath); isFile {
+			k.Resources = append(k.Resources, resourceName)
 		} else {
-			log.Warn("No kustomization found for resource, ", resourceName)
+			report.warn("No kustomization found for resource, ", resourceName)
 		}
 	}
 
@@ -43,10 +187,88 @@ func (k *Kustomization) generate(path string, resources []string, dryRun bool) e
 		return fmt.Errorf("cannot marshal kustomization: %w", err)
 	}
 	kustomizationFile := filepath.Join(path, "kustomization.yaml")
-	err = utils.WriteFile(kustomizationFile, kustomizationYAML, uint32(0666), dryRun)
+	action, err := writeFile(kustomizationFile, kustomizationYAML, dryRun)
 	if err != nil {
 		return fmt.Errorf("cannot write kustomization: %w", err)
 	}
+	report.file(kustomizationFile, action)
+
+	return nil
+}
+
+// Removes everything of the resource target but the rendered base, the
+// overlay kustomization and the created namespace.
+func (r *Resource) pruneOverlay(settings *Settings, report *ResourceReport, clusterPath string) error {
+	clusterResourcePath := r.pathCluster(settings, clusterPath)
+	err := utils.MkDir(clusterResourcePath, settings.DryRun)
+	if err != nil {
+		return err
+	}
+
+	keep := []string{resourceBaseDirectory, "kustomization.yaml"}
+	if r.CreateNamespace.enabled() && r.writesNamespace {
+		keep = append(keep, namespaceFile)
+	}
+	removed, err := utils.RemoveFilesAndDirectoriesExcept(clusterResourcePath, keep, settings.DryRun)
+	for _, removedPath := range removed {
+		report.file(removedPath, FileRemoved)
+	}
+
+	return err
+}
+
+// Resource kustomization, templated against the resource values, as an
+// overlay of the rendered base. A created namespace is the namespace of the
+// overlay.
+func (r *Resource) overlay(settings *Settings, values Values, clusterPath string) (*Kustomization, error) {
+	resourceKustomization := r.Kustomization
+	if resourceKustomization == nil {
+		resourceKustomization = &Kustomization{}
+	}
+
+	kustomization, err := resourceKustomization.render("kustomization", values, settings)
+	var templateError *TemplateError
+	if errors.As(err, &templateError) {
+		templateError.Cluster = clusterPath
+		templateError.Resource = r.Name
+		return nil, TemplateErrors{templateError}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot template kustomization: %w", err)
+	}
+	kustomization.APIVersion = "kustomize.config.k8s.io/v1beta1"
+	kustomization.Kind = "Kustomization"
+	kustomization.Resources = []string{resourceBaseDirectory}
+
+	if r.CreateNamespace.enabled() {
+		if kustomization.Namespace != "" && kustomization.Namespace != *r.Namespace {
+			return nil, fmt.Errorf("kustomization namespace %s differs from created namespace %s", kustomization.Namespace, *r.Namespace)
+		}
+		kustomization.Namespace = *r.Namespace
+		if r.writesNamespace {
+			kustomization.Resources = append(kustomization.Resources, namespaceFile)
+		}
+	}
+
+	return kustomization, nil
+}
+
+func (r *Resource) writeKustomization(settings *Settings, values Values, report *ResourceReport, clusterPath string) error {
+	kustomization, err := r.overlay(settings, values, clusterPath)
+	if err != nil {
+		return err
+	}
+
+	kustomizationYAML, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return fmt.Errorf("cannot marshal kustomization: %w", err)
+	}
+	kustomizationPath := filepath.Join(r.pathCluster(settings, clusterPath), "kustomization.yaml")
+	action, err := writeFile(kustomizationPath, kustomizationYAML, settings.DryRun)
+	if err != nil {
+		return err
+	}
+	report.file(kustomizationPath, action)
 
 	return nil
 }
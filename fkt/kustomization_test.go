@@ -0,0 +1,46 @@
+package fkt
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestTemplateNodeStyle(t *testing.T) {
+	settings := testSettings(t)
+	values := Values{"Values": Values{"team": "core", "replicas": 3}}
+
+	tests := []struct {
+		name  string
+		typed bool
+		value string
+		want  string
+	}{
+		{name: "string keeps style", value: "'[[[ .Values.team ]]]'", want: "'core'\n"},
+		{name: "plain string", value: "team-[[[ .Values.team ]]]", want: "team-core\n"},
+		{name: "typed field", typed: true, value: "'[[[ .Values.replicas ]]]'", want: "3\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var node yaml.Node
+			err := yaml.Unmarshal([]byte(test.value), &node)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = values.templateNode("test", node.Content[0], test.typed, settings)
+			if err != nil {
+				t.Fatalf("templateNode() error = %v", err)
+			}
+
+			got, err := yaml.Marshal(node.Content[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("templateNode() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
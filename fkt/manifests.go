@@ -0,0 +1,646 @@
+package fkt
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+//go:embed schemas/*.json
+var bundledSchemas embed.FS
+
+// Manifest of a rendered file failing validation, documents are numbered from
+// one.
+type ManifestError struct {
+	Cluster  string
+	Resource string
+	File     string
+	Document int
+	Message  string
+}
+
+func (e *ManifestError) Error() string {
+	location := e.File
+	if e.Document > 0 {
+		location = fmt.Sprintf("%s: document %d", location, e.Document)
+	}
+	if e.Resource == "" {
+		return fmt.Sprintf("%s: cluster %s: %s", location, e.Cluster, e.Message)
+	}
+
+	return fmt.Sprintf("%s: cluster %s, resource %s: %s", location, e.Cluster, e.Resource, e.Message)
+}
+
+type ManifestErrors []*ManifestError
+
+func (e ManifestErrors) Error() string {
+	var messages []string
+	for _, manifestError := range e {
+		messages = append(messages, manifestError.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (e ManifestErrors) Unwrap() []error {
+	var errs []error
+	for _, manifestError := range e {
+		errs = append(errs, manifestError)
+	}
+
+	return errs
+}
+
+// Subset of JSON Schema used by Kubernetes OpenAPI definitions and CRDs.
+type manifestSchema struct {
+	Ref                   string                     `json:"$ref"`
+	Type                  manifestSchemaTypes        `json:"type"`
+	Properties            map[string]*manifestSchema `json:"properties"`
+	AdditionalProperties  *manifestSchemaAdditional  `json:"additionalProperties"`
+	Items                 *manifestSchema            `json:"items"`
+	Required              []string                   `json:"required"`
+	Enum                  []interface{}              `json:"enum"`
+	AllOf                 []*manifestSchema          `json:"allOf"`
+	AnyOf                 []*manifestSchema          `json:"anyOf"`
+	OneOf                 []*manifestSchema          `json:"oneOf"`
+	IntOrString           bool                       `json:"x-kubernetes-int-or-string"`
+	PreserveUnknownFields bool                       `json:"x-kubernetes-preserve-unknown-fields"`
+	GroupVersionKind      []manifestGroupVersionKind `json:"x-kubernetes-group-version-kind"`
+	Definitions           map[string]*manifestSchema `json:"definitions"`
+	Defs                  map[string]*manifestSchema `json:"$defs"`
+	Components            struct {
+		Schemas map[string]*manifestSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type manifestSchemaTypes []string
+
+func (t *manifestSchemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = manifestSchemaTypes{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*t = multiple
+
+	return nil
+}
+
+// Either false, closing an object, or the schema of additional properties.
+type manifestSchemaAdditional struct {
+	closed bool
+	schema *manifestSchema
+}
+
+func (a *manifestSchemaAdditional) UnmarshalJSON(b []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(b, &allowed); err == nil {
+		a.closed = !allowed
+		return nil
+	}
+
+	a.schema = &manifestSchema{}
+	return json.Unmarshal(b, a.schema)
+}
+
+type manifestGroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (gvk manifestGroupVersionKind) key() string {
+	if gvk.Group == "" {
+		return gvk.Version + "/" + gvk.Kind
+	}
+
+	return gvk.Group + "/" + gvk.Version + "/" + gvk.Kind
+}
+
+// Schema with the document its references resolve against.
+type manifestSchemaRoot struct {
+	schema *manifestSchema
+	root   *manifestSchema
+}
+
+type manifestSchemas map[string]manifestSchemaRoot
+
+// Bundled schemas, overridden by JSON schemas and CRDs in the schema
+// directories of the validation settings.
+func loadManifestSchemas(settings *Settings) (manifestSchemas, error) {
+	schemas := manifestSchemas{}
+
+	bundled, err := fs.Glob(bundledSchemas, "schemas/*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range bundled {
+		b, err := bundledSchemas.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		err = schemas.addJSON(file, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, directory := range settings.Validation.Schemas {
+		directory = settings.pathValidationSchemas(directory)
+		err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".json":
+				return schemas.addJSON(path, b)
+			case ".yaml", ".yml":
+				return schemas.addCRDs(path, b)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot load schemas: %s; %w", directory, err)
+		}
+	}
+
+	return schemas, nil
+}
+
+func (schemas manifestSchemas) addJSON(path string, b []byte) error {
+	root := &manifestSchema{}
+	err := json.Unmarshal(b, root)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %s; %w", path, err)
+	}
+
+	candidates := []*manifestSchema{root}
+	for _, definitions := range []map[string]*manifestSchema{root.Definitions, root.Defs, root.Components.Schemas} {
+		for _, definition := range definitions {
+			candidates = append(candidates, definition)
+		}
+	}
+
+	added := 0
+	for _, candidate := range candidates {
+		for _, gvk := range candidate.GroupVersionKind {
+			schemas[gvk.key()] = manifestSchemaRoot{schema: candidate, root: root}
+			added++
+		}
+	}
+
+	// Standalone schemas without group, version and kind enumerate them.
+	if added == 0 {
+		apiVersion, kind := root.Properties["apiVersion"], root.Properties["kind"]
+		if apiVersion != nil && kind != nil && len(apiVersion.Enum) > 0 && len(kind.Enum) > 0 {
+			schemas[fmt.Sprint(apiVersion.Enum[0], "/", kind.Enum[0])] = manifestSchemaRoot{schema: root, root: root}
+			added++
+		}
+	}
+	log.Debug("Loaded ", added, " manifest schemas from: ", path)
+
+	return nil
+}
+
+func (schemas manifestSchemas) addCRDs(path string, b []byte) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		documentJSON, err := utilyaml.ToJSON(document)
+		if err != nil {
+			return fmt.Errorf("invalid CRD: %s; %w", path, err)
+		}
+		crd := struct {
+			Kind string `json:"kind"`
+			Spec struct {
+				Group string `json:"group"`
+				Names struct {
+					Kind string `json:"kind"`
+				} `json:"names"`
+				Versions []struct {
+					Name   string `json:"name"`
+					Schema struct {
+						OpenAPIV3Schema *manifestSchema `json:"openAPIV3Schema"`
+					} `json:"schema"`
+				} `json:"versions"`
+			} `json:"spec"`
+		}{}
+		err = json.Unmarshal(documentJSON, &crd)
+		if err != nil {
+			return fmt.Errorf("invalid CRD: %s; %w", path, err)
+		}
+		if crd.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		for _, version := range crd.Spec.Versions {
+			schema := version.Schema.OpenAPIV3Schema
+			if schema == nil {
+				continue
+			}
+			gvk := manifestGroupVersionKind{Group: crd.Spec.Group, Version: version.Name, Kind: crd.Spec.Names.Kind}
+			schemas[gvk.key()] = manifestSchemaRoot{schema: schema, root: schema}
+			log.Debug("Loaded CRD schema ", gvk.key(), " from: ", path)
+		}
+	}
+
+	return nil
+}
+
+// Validates the documents of a manifest, returning a message per failure with
+// the document number and the kinds without a schema.
+func (schemas manifestSchemas) validate(b []byte) (map[int][]string, []string) {
+	failures := map[int][]string{}
+	var unvalidated []string
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for index := 1; ; index++ {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failures[index] = append(failures[index], err.Error())
+			break
+		}
+
+		var object interface{}
+		err = utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(document), len(document)).Decode(&object)
+		if err == io.EOF || (err == nil && object == nil) {
+			continue
+		}
+		if err != nil {
+			failures[index] = append(failures[index], fmt.Sprintf("cannot parse: %s", err))
+			continue
+		}
+
+		var kind string
+		failures[index], kind = schemas.validateObject(object)
+		if len(failures[index]) == 0 {
+			delete(failures, index)
+		}
+		if kind != "" && !slices.Contains(unvalidated, kind) {
+			unvalidated = append(unvalidated, kind)
+		}
+	}
+
+	return failures, unvalidated
+}
+
+// Kinds without a schema are not validated and returned.
+func (schemas manifestSchemas) validateObject(object interface{}) ([]string, string) {
+	manifest, ok := object.(map[string]interface{})
+	if !ok {
+		return []string{"not an object"}, ""
+	}
+
+	var failures []string
+	apiVersion, _ := manifest["apiVersion"].(string)
+	if apiVersion == "" {
+		failures = append(failures, "apiVersion unset")
+	}
+	kind, _ := manifest["kind"].(string)
+	if kind == "" {
+		failures = append(failures, "kind unset")
+	}
+	metadata, _ := manifest["metadata"].(map[string]interface{})
+	if name, _ := metadata["name"].(string); name == "" {
+		failures = append(failures, "metadata.name unset")
+	}
+	if len(failures) > 0 {
+		return failures, ""
+	}
+
+	schema, ok := schemas[apiVersion+"/"+kind]
+	if !ok {
+		return nil, apiVersion + " " + kind
+	}
+
+	// Metadata of SOPS encrypted documents is not part of the object.
+	delete(manifest, "sops")
+
+	return schema.validate(manifest, schema.schema, ""), ""
+}
+
+func (s manifestSchemaRoot) resolve(schema *manifestSchema) *manifestSchema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 32; depth++ {
+		var definitions map[string]*manifestSchema
+		var name string
+		switch {
+		case strings.HasPrefix(schema.Ref, "#/definitions/"):
+			definitions, name = s.root.Definitions, strings.TrimPrefix(schema.Ref, "#/definitions/")
+		case strings.HasPrefix(schema.Ref, "#/$defs/"):
+			definitions, name = s.root.Defs, strings.TrimPrefix(schema.Ref, "#/$defs/")
+		case strings.HasPrefix(schema.Ref, "#/components/schemas/"):
+			definitions, name = s.root.Components.Schemas, strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		}
+
+		resolved, ok := definitions[name]
+		if !ok {
+			log.Trace("Unresolved schema reference: ", schema.Ref)
+			return nil
+		}
+		schema = resolved
+	}
+
+	return schema
+}
+
+func (s manifestSchemaRoot) validate(value interface{}, schema *manifestSchema, path string) []string {
+	schema = s.resolve(schema)
+	if schema == nil || value == nil {
+		return nil
+	}
+
+	field := strings.TrimPrefix(path, ".")
+	if field == "" {
+		field = "."
+	}
+
+	var failures []string
+	for _, allOf := range schema.AllOf {
+		failures = append(failures, s.validate(value, allOf, path)...)
+	}
+	if len(schema.AnyOf) > 0 && s.matches(value, schema.AnyOf, path) == 0 {
+		failures = append(failures, fmt.Sprintf("%s: does not match any allowed schema", field))
+	}
+	if len(schema.OneOf) > 0 {
+		matches := s.matches(value, schema.OneOf, path)
+		if matches == 0 {
+			failures = append(failures, fmt.Sprintf("%s: does not match any allowed schema", field))
+		} else if matches > 1 {
+			failures = append(failures, fmt.Sprintf("%s: matches more than one allowed schema", field))
+		}
+	}
+
+	if schema.IntOrString {
+		switch value.(type) {
+		case string, int64:
+		case float64:
+			if !isInteger(value) {
+				failures = append(failures, fmt.Sprintf("%s: must be an integer or string", field))
+			}
+		default:
+			failures = append(failures, fmt.Sprintf("%s: must be an integer or string", field))
+		}
+		return failures
+	}
+
+	if len(schema.Type) > 0 && !slices.ContainsFunc(schema.Type, func(schemaType string) bool { return isType(value, schemaType) }) {
+		return append(failures, fmt.Sprintf("%s: must be %s, not %s", field, strings.Join(schema.Type, " or "), typeName(value)))
+	}
+
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(enum interface{}) bool { return reflect.DeepEqual(enum, value) }) {
+		failures = append(failures, fmt.Sprintf("%s: %v is not one of %v", field, value, schema.Enum))
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for _, required := range schema.Required {
+			if _, ok := typedValue[required]; !ok {
+				failures = append(failures, fmt.Sprintf("%s: %s is required", field, required))
+			}
+		}
+
+		keys := make([]string, 0, len(typedValue))
+		for key := range typedValue {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if property, ok := schema.Properties[key]; ok {
+				failures = append(failures, s.validate(typedValue[key], property, path+"."+key)...)
+				continue
+			}
+			if schema.AdditionalProperties == nil || schema.PreserveUnknownFields {
+				continue
+			}
+			if schema.AdditionalProperties.closed {
+				failures = append(failures, fmt.Sprintf("%s: unknown field %s", field, key))
+				continue
+			}
+			failures = append(failures, s.validate(typedValue[key], schema.AdditionalProperties.schema, path+"."+key)...)
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for index, item := range typedValue {
+				failures = append(failures, s.validate(item, schema.Items, fmt.Sprintf("%s[%d]", path, index))...)
+			}
+		}
+	}
+
+	return failures
+}
+
+// Number of alternatives value is valid against.
+func (s manifestSchemaRoot) matches(value interface{}, alternatives []*manifestSchema, path string) int {
+	matches := 0
+	for _, alternative := range alternatives {
+		if len(s.validate(value, alternative, path)) == 0 {
+			matches++
+		}
+	}
+
+	return matches
+}
+
+func isInteger(value interface{}) bool {
+	switch number := value.(type) {
+	case int64:
+		return true
+	case float64:
+		return number == math.Trunc(number)
+	}
+
+	return false
+}
+
+func isType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		return isInteger(value)
+	case "number":
+		switch value.(type) {
+		case int64, float64:
+			return true
+		}
+		return false
+	case "null":
+		return value == nil
+	}
+
+	return true
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int64, float64:
+		return "number"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// Validates the manifests rendered by the run, the files listed as resources
+// of the kustomization in their directory.
+func (config *Config) validateManifests() error {
+	log.Info("Validating rendered manifests...")
+	schemas, err := loadManifestSchemas(config.Settings)
+	if err != nil {
+		return err
+	}
+
+	resources := map[string][]string{}
+	isManifest := func(path string) bool {
+		directory := filepath.Dir(path)
+		if _, ok := resources[directory]; !ok {
+			resources[directory] = kustomizationResources(directory)
+		}
+		return slices.ContainsFunc(resources[directory], func(resource string) bool {
+			return filepath.Clean(resource) == filepath.Base(path)
+		})
+	}
+
+	var manifestErrors ManifestErrors
+	validateFiles := func(clusterPath, resourceName string, files []FileReport, warn func(args ...interface{})) {
+		warned := map[string]bool{}
+		files = slices.Clone(files)
+		slices.SortFunc(files, func(a, b FileReport) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+		for _, file := range files {
+			path := filepath.Join(config.report.base, file.Path)
+			if file.Action == FileRemoved || !isManifest(path) {
+				continue
+			}
+
+			b, err := utils.ReadFile(path)
+			if err != nil {
+				manifestErrors = append(manifestErrors, &ManifestError{
+					Cluster: clusterPath, Resource: resourceName, File: file.Path, Message: err.Error(),
+				})
+				continue
+			}
+
+			failures, unvalidated := schemas.validate(b)
+			for _, kind := range unvalidated {
+				if !warned[kind] {
+					warn("No schema for ", kind, ", not validated: ", file.Path)
+					warned[kind] = true
+				}
+			}
+			documents := make([]int, 0, len(failures))
+			for document := range failures {
+				documents = append(documents, document)
+			}
+			slices.Sort(documents)
+			for _, document := range documents {
+				for _, failure := range failures[document] {
+					manifestErrors = append(manifestErrors, &ManifestError{
+						Cluster: clusterPath, Resource: resourceName, File: file.Path, Document: document, Message: failure,
+					})
+				}
+			}
+		}
+	}
+
+	clusterPaths := make([]string, 0, len(config.report.Clusters))
+	for clusterPath := range config.report.Clusters {
+		clusterPaths = append(clusterPaths, clusterPath)
+	}
+	slices.Sort(clusterPaths)
+	for _, clusterPath := range clusterPaths {
+		clusterReport := config.report.Clusters[clusterPath]
+		validateFiles(clusterPath, "", clusterReport.Files, clusterReport.warn)
+
+		resourceNames := make([]string, 0, len(clusterReport.Resources))
+		for resourceName := range clusterReport.Resources {
+			resourceNames = append(resourceNames, resourceName)
+		}
+		slices.Sort(resourceNames)
+		for _, resourceName := range resourceNames {
+			resourceReport := clusterReport.Resources[resourceName]
+			validateFiles(clusterPath, resourceName, resourceReport.Files, resourceReport.warn)
+		}
+	}
+
+	if len(manifestErrors) > 0 {
+		return manifestErrors
+	}
+
+	return nil
+}
+
+func kustomizationResources(directory string) []string {
+	for _, name := range []string{"kustomization.yaml", "kustomization.yml", "Kustomization"} {
+		b, err := utils.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			continue
+		}
+
+		kustomization := struct {
+			Resources []string `yaml:"resources"`
+		}{}
+		if err := yaml.Unmarshal(b, &kustomization); err != nil {
+			log.Debug("Cannot parse kustomization in ", directory, ": ", err)
+			return nil
+		}
+		return kustomization.Resources
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package fkt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestManifestSchemaValidate(t *testing.T) {
+	schemas := manifestSchemas{}
+	err := schemas.addJSON("test.json", []byte(`{
+  "definitions": {
+    "Port": {
+      "x-kubernetes-int-or-string": true
+    },
+    "Labels": {
+      "type": "object",
+      "additionalProperties": {"type": "string"}
+    },
+    "Widget": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/Metadata"},
+        "spec": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "port": {"$ref": "#/definitions/Port"},
+            "labels": {"$ref": "#/$defs/Labels"},
+            "size": {"$ref": "#/components/schemas/Size"},
+            "source": {
+              "oneOf": [
+                {"type": "object", "required": ["git"]},
+                {"type": "object", "required": ["oci"]}
+              ]
+            },
+            "replicas": {
+              "anyOf": [
+                {"type": "integer"},
+                {"type": "string", "enum": ["auto"]}
+              ]
+            }
+          }
+        }
+      },
+      "x-kubernetes-group-version-kind": [{"group": "example.com", "version": "v1", "kind": "Widget"}]
+    },
+    "Metadata": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"}
+      }
+    }
+  },
+  "$defs": {
+    "Labels": {"$ref": "#/definitions/Labels"}
+  },
+  "components": {
+    "schemas": {
+      "Size": {"type": "string", "enum": ["small", "large"]}
+    }
+  }
+}`))
+	if err != nil {
+		t.Fatalf("addJSON() error = %v", err)
+	}
+
+	widget := func(spec map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]interface{}{"name": "widget"},
+			"spec":       spec,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		object   interface{}
+		failures []string
+		kind     string
+	}{
+		{
+			name:   "valid",
+			object: widget(map[string]interface{}{"port": "http", "labels": map[string]interface{}{"app": "widget"}, "size": "small"}),
+		},
+		{
+			name:     "closed object",
+			object:   widget(map[string]interface{}{"colour": "red"}),
+			failures: []string{"spec: unknown field colour"},
+		},
+		{
+			name:   "int or string integer",
+			object: widget(map[string]interface{}{"port": int64(8080)}),
+		},
+		{
+			name:   "int or string whole float",
+			object: widget(map[string]interface{}{"port": float64(8080)}),
+		},
+		{
+			name:     "int or string fraction",
+			object:   widget(map[string]interface{}{"port": 80.5}),
+			failures: []string{"spec.port: must be an integer or string"},
+		},
+		{
+			name:     "int or string boolean",
+			object:   widget(map[string]interface{}{"port": true}),
+			failures: []string{"spec.port: must be an integer or string"},
+		},
+		{
+			name:     "defs reference to definitions",
+			object:   widget(map[string]interface{}{"labels": map[string]interface{}{"replicas": int64(2)}}),
+			failures: []string{"spec.labels.replicas: must be string, not number"},
+		},
+		{
+			name:     "components reference",
+			object:   widget(map[string]interface{}{"size": "medium"}),
+			failures: []string{"spec.size: medium is not one of [small large]"},
+		},
+		{
+			name:   "one of",
+			object: widget(map[string]interface{}{"source": map[string]interface{}{"git": "https://example.com"}}),
+		},
+		{
+			name:     "one of none",
+			object:   widget(map[string]interface{}{"source": map[string]interface{}{"s3": "bucket"}}),
+			failures: []string{"spec.source: does not match any allowed schema"},
+		},
+		{
+			name:     "one of several",
+			object:   widget(map[string]interface{}{"source": map[string]interface{}{"git": "https://example.com", "oci": "oci://example.com"}}),
+			failures: []string{"spec.source: matches more than one allowed schema"},
+		},
+		{
+			name:   "any of several",
+			object: widget(map[string]interface{}{"replicas": int64(2)}),
+		},
+		{
+			name:     "any of none",
+			object:   widget(map[string]interface{}{"replicas": "manual"}),
+			failures: []string{"spec.replicas: does not match any allowed schema"},
+		},
+		{
+			name:   "no schema",
+			object: map[string]interface{}{"apiVersion": "example.com/v1", "kind": "Gadget", "metadata": map[string]interface{}{"name": "gadget"}},
+			kind:   "example.com/v1 Gadget",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures, kind := schemas.validateObject(test.object)
+			if !reflect.DeepEqual(failures, test.failures) {
+				t.Errorf("validateObject() failures = %q, want %q", failures, test.failures)
+			}
+			if kind != test.kind {
+				t.Errorf("validateObject() kind = %q, want %q", kind, test.kind)
+			}
+		})
+	}
+}
+
+func TestManifestSchemaCRDs(t *testing.T) {
+	schemas := manifestSchemas{}
+	err := schemas.addCRDs("crds.yaml", []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+  versions:
+    - name: v1alpha1
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              properties:
+                size:
+                  type: string
+    - name: v1
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            spec:
+              type: object
+              required:
+                - size
+              properties:
+                size:
+                  type: integer
+                extra:
+                  type: object
+                  x-kubernetes-preserve-unknown-fields: true
+                  additionalProperties: false
+`))
+	if err != nil {
+		t.Fatalf("addCRDs() error = %v", err)
+	}
+
+	if len(schemas) != 2 {
+		t.Fatalf("addCRDs() loaded %d schemas, want 2", len(schemas))
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		failures map[int][]string
+	}{
+		{
+			name: "version schema",
+			manifest: `apiVersion: example.com/v1alpha1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: small
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: small
+`,
+			failures: map[int][]string{2: {"spec.size: must be integer, not string"}},
+		},
+		{
+			name: "required",
+			manifest: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec: {}
+`,
+			failures: map[int][]string{1: {"spec: size is required"}},
+		},
+		{
+			name: "preserve unknown fields",
+			manifest: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  size: 2
+  extra:
+    anything: true
+`,
+			failures: map[int][]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failures, unvalidated := schemas.validate([]byte(test.manifest))
+			if !reflect.DeepEqual(failures, test.failures) {
+				t.Errorf("validate() failures = %q, want %q", failures, test.failures)
+			}
+			if len(unvalidated) > 0 {
+				t.Errorf("validate() unvalidated = %q", unvalidated)
+			}
+		})
+	}
+}
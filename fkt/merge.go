@@ -0,0 +1,183 @@
+package fkt
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Merge struct {
+	Strategy MergeStrategy `yaml:"strategy"`
+	Lists    ListStrategy  `yaml:"lists"`
+	Key      string        `yaml:"key"`
+}
+
+type MergeStrategy string
+
+const (
+	OverwriteMerge MergeStrategy = "overwrite"
+	DeepMerge      MergeStrategy = "deep"
+)
+
+type ListStrategy string
+
+const (
+	ReplaceLists ListStrategy = "replace"
+	AppendLists  ListStrategy = "append"
+	MergeLists   ListStrategy = "merge"
+)
+
+var mergeDefaults = Merge{
+	Strategy: OverwriteMerge,
+	Lists:    ReplaceLists,
+	Key:      "name",
+}
+
+func (s *MergeStrategy) UnmarshalYAML(value *yaml.Node) error {
+	var strategyStr string
+	if err := value.Decode(&strategyStr); err != nil {
+		return err
+	}
+
+	switch MergeStrategy(strategyStr) {
+	case OverwriteMerge, DeepMerge:
+		*s = MergeStrategy(strategyStr)
+	default:
+		return fmt.Errorf("line %d: unknown merge strategy: %s", value.Line, strategyStr)
+	}
+
+	return nil
+}
+
+func (s *ListStrategy) UnmarshalYAML(value *yaml.Node) error {
+	var strategyStr string
+	if err := value.Decode(&strategyStr); err != nil {
+		return err
+	}
+
+	switch ListStrategy(strategyStr) {
+	case ReplaceLists, AppendLists, MergeLists:
+		*s = ListStrategy(strategyStr)
+	default:
+		return fmt.Errorf("line %d: unknown list merge strategy: %s", value.Line, strategyStr)
+	}
+
+	return nil
+}
+
+// Fields set on override take precedence, unset fields are inherited.
+func (m Merge) override(override *Merge) Merge {
+	if override == nil {
+		return m
+	}
+
+	if override.Strategy != "" {
+		m.Strategy = override.Strategy
+	}
+	if override.Lists != "" {
+		m.Lists = override.Lists
+	}
+	if override.Key != "" {
+		m.Key = override.Key
+	}
+
+	return m
+}
+
+// Neither dst nor src are modified, nested maps and lists are copied as
+// needed so values shared between clusters are never mutated.
+func (m Merge) values(dst, src Values) Values {
+	v := Values{}
+	for key, value := range dst {
+		v[key] = value
+	}
+
+	for key, value := range src {
+		if m.Strategy == DeepMerge {
+			if existing, ok := v[key]; ok {
+				v[key] = m.merge(existing, value)
+				continue
+			}
+		}
+		v[key] = value
+	}
+
+	return v
+}
+
+func (m Merge) merge(dst, src interface{}) interface{} {
+	if srcMap, ok := toValues(src); ok {
+		if dstMap, ok := toValues(dst); ok {
+			return m.values(dstMap, srcMap)
+		}
+	}
+
+	if srcList, ok := src.([]interface{}); ok {
+		if dstList, ok := dst.([]interface{}); ok {
+			return m.lists(dstList, srcList)
+		}
+	}
+
+	return src
+}
+
+func (m Merge) lists(dst, src []interface{}) []interface{} {
+	switch m.Lists {
+	case AppendLists:
+		l := make([]interface{}, 0, len(dst)+len(src))
+		l = append(l, dst...)
+		return append(l, src...)
+	case MergeLists:
+		l := make([]interface{}, len(dst))
+		copy(l, dst)
+
+		for _, item := range src {
+			index := m.keyIndex(l, item)
+			if index < 0 {
+				l = append(l, item)
+				continue
+			}
+			l[index] = m.merge(l[index], item)
+		}
+
+		return l
+	default:
+		return src
+	}
+}
+
+func (m Merge) keyIndex(list []interface{}, item interface{}) int {
+	itemMap, ok := toValues(item)
+	if !ok {
+		return -1
+	}
+	itemKey, ok := itemMap[m.Key]
+	if !ok {
+		return -1
+	}
+
+	for index, existing := range list {
+		existingMap, ok := toValues(existing)
+		if !ok {
+			continue
+		}
+		if existingKey, ok := existingMap[m.Key]; ok && reflect.DeepEqual(existingKey, itemKey) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// Nested maps decode as either Values or map[string]interface{}
+func toValues(value interface{}) (Values, bool) {
+	switch v := value.(type) {
+	case Values:
+		return v, true
+	case map[string]interface{}:
+		return Values(v), true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,139 @@
+package fkt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeValues(t *testing.T) {
+	dst := Values{
+		"name": "global",
+		"image": map[string]interface{}{
+			"repository": "nginx",
+			"tag":        "1.24",
+		},
+		"ports": []interface{}{80},
+		"env": []interface{}{
+			map[string]interface{}{"name": "LOG", "value": "info"},
+			map[string]interface{}{"name": "MODE", "value": "a"},
+		},
+	}
+	src := Values{
+		"image": Values{
+			"tag": "1.25",
+		},
+		"ports": []interface{}{443},
+		"env": []interface{}{
+			map[string]interface{}{"name": "MODE", "value": "b"},
+			map[string]interface{}{"name": "DEBUG", "value": "1"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		merge Merge
+		want  Values
+	}{
+		{
+			name:  "overwrite",
+			merge: Merge{Strategy: OverwriteMerge, Lists: ReplaceLists, Key: "name"},
+			want: Values{
+				"name":  "global",
+				"image": Values{"tag": "1.25"},
+				"ports": []interface{}{443},
+				"env": []interface{}{
+					map[string]interface{}{"name": "MODE", "value": "b"},
+					map[string]interface{}{"name": "DEBUG", "value": "1"},
+				},
+			},
+		},
+		{
+			name:  "deep replace lists",
+			merge: Merge{Strategy: DeepMerge, Lists: ReplaceLists, Key: "name"},
+			want: Values{
+				"name":  "global",
+				"image": Values{"repository": "nginx", "tag": "1.25"},
+				"ports": []interface{}{443},
+				"env": []interface{}{
+					map[string]interface{}{"name": "MODE", "value": "b"},
+					map[string]interface{}{"name": "DEBUG", "value": "1"},
+				},
+			},
+		},
+		{
+			name:  "deep append lists",
+			merge: Merge{Strategy: DeepMerge, Lists: AppendLists, Key: "name"},
+			want: Values{
+				"name":  "global",
+				"image": Values{"repository": "nginx", "tag": "1.25"},
+				"ports": []interface{}{80, 443},
+				"env": []interface{}{
+					map[string]interface{}{"name": "LOG", "value": "info"},
+					map[string]interface{}{"name": "MODE", "value": "a"},
+					map[string]interface{}{"name": "MODE", "value": "b"},
+					map[string]interface{}{"name": "DEBUG", "value": "1"},
+				},
+			},
+		},
+		{
+			name:  "deep merge lists by key",
+			merge: Merge{Strategy: DeepMerge, Lists: MergeLists, Key: "name"},
+			want: Values{
+				"name":  "global",
+				"image": Values{"repository": "nginx", "tag": "1.25"},
+				"ports": []interface{}{80, 443},
+				"env": []interface{}{
+					map[string]interface{}{"name": "LOG", "value": "info"},
+					Values{"name": "MODE", "value": "b"},
+					map[string]interface{}{"name": "DEBUG", "value": "1"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.merge.values(dst, src)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("values() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMergeValuesDoesNotModifyInputs(t *testing.T) {
+	dst := Values{"image": map[string]interface{}{"tag": "1.24"}, "ports": []interface{}{80}}
+	src := Values{"image": map[string]interface{}{"repository": "nginx"}, "ports": []interface{}{443}}
+
+	Merge{Strategy: DeepMerge, Lists: AppendLists, Key: "name"}.values(dst, src)
+
+	if want := (Values{"image": map[string]interface{}{"tag": "1.24"}, "ports": []interface{}{80}}); !reflect.DeepEqual(dst, want) {
+		t.Errorf("dst = %#v, want %#v", dst, want)
+	}
+	if want := (Values{"image": map[string]interface{}{"repository": "nginx"}, "ports": []interface{}{443}}); !reflect.DeepEqual(src, want) {
+		t.Errorf("src = %#v, want %#v", src, want)
+	}
+}
+
+func TestMergeOverride(t *testing.T) {
+	merge := mergeDefaults.override(&Merge{Lists: MergeLists})
+
+	want := Merge{Strategy: OverwriteMerge, Lists: MergeLists, Key: "name"}
+	if merge != want {
+		t.Errorf("override() = %#v, want %#v", merge, want)
+	}
+	if merge := mergeDefaults.override(nil); merge != mergeDefaults {
+		t.Errorf("override(nil) = %#v, want %#v", merge, mergeDefaults)
+	}
+}
+
+func TestProcessValues(t *testing.T) {
+	global := Values{"a": 1, "nested": map[string]interface{}{"x": 1}}
+	cluster := Values{"b": 2, "nested": map[string]interface{}{"y": 2}}
+
+	got := ProcessValues(&global, nil, &cluster)
+	want := Values{"a": 1, "b": 2, "nested": map[string]interface{}{"y": 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessValues() = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/exp/maps"
+	"gopkg.in/yaml.v3"
+)
+
+const namespaceFile = "namespace.yaml"
+
+// Namespace manifest generated for the resource namespace, enabled unless
+// enabled is false.
+type CreateNamespace struct {
+	Enabled     *bool             `yaml:"enabled"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type namespaceManifest struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Labels      map[string]string `yaml:"labels,omitempty"`
+		Annotations map[string]string `yaml:"annotations,omitempty"`
+	} `yaml:"metadata"`
+}
+
+func (n *CreateNamespace) enabled() bool {
+	return n != nil && (n.Enabled == nil || *n.Enabled)
+}
+
+// Labels and annotations are templated against values.
+func (n *CreateNamespace) manifest(name string, values Values, settings *Settings) (namespaceManifest, error) {
+	namespace := namespaceManifest{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+	}
+	namespace.Metadata.Name = name
+
+	var err error
+	namespace.Metadata.Labels, err = values.templateStrings("create_namespace.labels", n.Labels, settings)
+	if err != nil {
+		return namespace, err
+	}
+	namespace.Metadata.Annotations, err = values.templateStrings("create_namespace.annotations", n.Annotations, settings)
+	if err != nil {
+		return namespace, err
+	}
+
+	return namespace, nil
+}
+
+func (v *Values) templateStrings(name string, fields map[string]string, settings *Settings) (map[string]string, error) {
+	if fields == nil {
+		return nil, nil
+	}
+
+	templated := make(map[string]string, len(fields))
+	for key, value := range fields {
+		templatedKey, err := v.execute(name, key, 0, settings)
+		if err != nil {
+			return nil, err
+		}
+		templatedValue, err := v.execute(name, value, 0, settings)
+		if err != nil {
+			return nil, err
+		}
+		templated[templatedKey.String()] = templatedValue.String()
+	}
+
+	return templated, nil
+}
+
+// Writes the namespace manifest, labels and annotations are templated against
+// the resource values.
+func (r *Resource) writeNamespace(settings *Settings, values Values, report *ResourceReport, clusterPath string) error {
+	namespace, err := r.CreateNamespace.manifest(*r.Namespace, values, settings)
+	var templateError *TemplateError
+	if errors.As(err, &templateError) {
+		templateError.Cluster = clusterPath
+		templateError.Resource = r.Name
+		return TemplateErrors{templateError}
+	}
+	if err != nil {
+		return err
+	}
+
+	namespaceYAML, err := yaml.Marshal(namespace)
+	if err != nil {
+		return fmt.Errorf("cannot marshal namespace: %w", err)
+	}
+
+	namespacePath := filepath.Join(r.pathCluster(settings, clusterPath), namespaceFile)
+	action, err := writeFile(namespacePath, namespaceYAML, settings.DryRun)
+	if err != nil {
+		return err
+	}
+	report.file(namespacePath, action)
+
+	return nil
+}
+
+// Resources creating each namespace, sorted by name.
+func (c *Cluster) namespaceCreators() map[string][]string {
+	creators := map[string][]string{}
+	for name, resource := range c.Resources {
+		if resource == nil || !resource.CreateNamespace.enabled() {
+			continue
+		}
+		creators[*resource.Namespace] = append(creators[*resource.Namespace], name)
+	}
+	for _, resources := range creators {
+		slices.Sort(resources)
+	}
+
+	return creators
+}
+
+// Kustomize and Flux reject the same Namespace from several resources, it is
+// written by the first managed resource creating it, the others only set it
+// as the namespace of their overlay. Other managed resources in the namespace
+// record the resource writing it.
+func (c *Cluster) assignNamespaces() {
+	writers := map[string]string{}
+	for namespace, resources := range c.namespaceCreators() {
+		for _, name := range resources {
+			resource := c.Resources[name]
+			resource.writesNamespace = writers[namespace] == "" && *resource.Managed
+			if resource.writesNamespace {
+				writers[namespace] = name
+			}
+		}
+	}
+
+	for name, resource := range c.Resources {
+		resource.namespaceWriter = ""
+		if writer := writers[*resource.Namespace]; writer != name && *resource.Managed {
+			resource.namespaceWriter = writer
+		}
+	}
+}
+
+// Resources may create the same namespace when their labels and annotations
+// are identical. The namespace must be applied before the other resources in
+// it, Flux applies them after the resource writing it, so they are applied by
+// Flux when it is.
+func (c *Cluster) validateNamespaces() error {
+	creators := c.namespaceCreators()
+
+	namespaces := maps.Keys(creators)
+	slices.Sort(namespaces)
+	for _, namespace := range namespaces {
+		resources := creators[namespace]
+		first := c.Resources[resources[0]].CreateNamespace
+		for _, name := range resources[1:] {
+			createNamespace := c.Resources[name].CreateNamespace
+			if !maps.Equal(first.Labels, createNamespace.Labels) || !maps.Equal(first.Annotations, createNamespace.Annotations) {
+				return fmt.Errorf("cluster %s: namespace %s is created by resources %s and %s with different labels or annotations", *c.path, namespace, resources[0], name)
+			}
+		}
+	}
+
+	names := maps.Keys(c.Resources)
+	slices.Sort(names)
+	for _, name := range names {
+		writer := c.Resources[name].namespaceWriter
+		if writer == "" || !c.Flux.override(c.Resources[writer].Flux).enabled() {
+			continue
+		}
+		flux := c.Flux.override(c.Resources[name].Flux)
+		if !flux.enabled() {
+			return fmt.Errorf("cluster %s: resource %s is not applied by flux, but resource %s creating its namespace %s is", *c.path, name, writer, *c.Resources[name].Namespace)
+		}
+		if slices.Contains(c.Flux.override(c.Resources[writer].Flux).DependsOn, name) {
+			return fmt.Errorf("cluster %s: resource %s creating namespace %s cannot depend on resource %s in it", *c.path, writer, *c.Resources[name].Namespace, name)
+		}
+	}
+
+	return nil
+}
+
+func (r *Resource) validateNamespace(name string) error {
+	if r.CreateNamespace.enabled() && *r.Namespace == "" {
+		return fmt.Errorf("resource %s: create_namespace requires a namespace", name)
+	}
+
+	return nil
+}
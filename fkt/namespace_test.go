@@ -0,0 +1,256 @@
+package fkt
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestClusterNamespaces(t *testing.T) {
+	restricted := map[string]string{"pod-security.kubernetes.io/enforce": "restricted"}
+	disabled := false
+
+	tests := []struct {
+		name      string
+		resources map[string]*Resource
+		writers   []string
+		err       string
+	}{
+		{
+			name: "single creator",
+			resources: map[string]*Resource{
+				"app": {CreateNamespace: &CreateNamespace{}},
+				"web": {},
+			},
+			writers: []string{"app"},
+		},
+		{
+			name: "identical creators",
+			resources: map[string]*Resource{
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{Labels: restricted}},
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{Labels: restricted}},
+			},
+			writers: []string{"app"},
+		},
+		{
+			name: "unmanaged first creator",
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), Managed: &disabled, CreateNamespace: &CreateNamespace{}},
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+			},
+			writers: []string{"web"},
+		},
+		{
+			name: "disabled creator",
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{Enabled: &disabled, Labels: restricted}},
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+			},
+			writers: []string{"web"},
+		},
+		{
+			name: "different labels",
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{Labels: restricted}},
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+			},
+			err: "cluster dev: namespace shared is created by resources app and web with different labels or annotations",
+		},
+		{
+			name: "different annotations",
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{Annotations: map[string]string{"owner": "a"}}},
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{Annotations: map[string]string{"owner": "b"}}},
+			},
+			err: "cluster dev: namespace shared is created by resources app and web with different labels or annotations",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, resource := range test.resources {
+				if resource.Namespace != nil {
+					*resource.Namespace = "shared"
+				}
+			}
+			cluster := &Cluster{Resources: test.resources}
+			cluster.load("dev")
+
+			err := cluster.validateNamespaces()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("validateNamespaces() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateNamespaces() error = %v", err)
+			}
+
+			var writers []string
+			for name, resource := range cluster.Resources {
+				if resource.writesNamespace {
+					writers = append(writers, name)
+				}
+			}
+			slices.Sort(writers)
+			if !reflect.DeepEqual(writers, test.writers) {
+				t.Errorf("resources writing the namespace = %v, want %v", writers, test.writers)
+			}
+		})
+	}
+}
+
+func TestResourceNamespaceOverlay(t *testing.T) {
+	settings := testSettings(t)
+	namespace := "team-a"
+
+	for _, writesNamespace := range []bool{true, false} {
+		resource := &Resource{Namespace: &namespace, CreateNamespace: &CreateNamespace{}, writesNamespace: writesNamespace}
+		resource.load("app")
+
+		kustomization, err := resource.overlay(settings, Values{}, "dev")
+		if err != nil {
+			t.Fatalf("overlay() error = %v", err)
+		}
+
+		want := []string{resourceBaseDirectory}
+		if writesNamespace {
+			want = append(want, namespaceFile)
+		}
+		if kustomization.Namespace != namespace || !reflect.DeepEqual(kustomization.Resources, want) {
+			t.Errorf("overlay() namespace = %s, resources = %v, want %s, %v", kustomization.Namespace, kustomization.Resources, namespace, want)
+		}
+	}
+}
+
+func TestClusterNamespaceFluxDependencies(t *testing.T) {
+	settings := testSettings(t)
+	disabled := false
+
+	tests := []struct {
+		name      string
+		flux      *Flux
+		resources map[string]*Resource
+		dependsOn map[string][]string
+		err       string
+	}{
+		{
+			name: "resource in created namespace",
+			flux: &Flux{},
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+				"web": {Namespace: new(string)},
+			},
+			dependsOn: map[string][]string{"app": nil, "web": {"app"}},
+		},
+		{
+			name: "identical creators",
+			flux: &Flux{},
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+				"web": {Namespace: new(string), CreateNamespace: &CreateNamespace{}, Flux: &Flux{DependsOn: []string{"app"}}},
+			},
+			dependsOn: map[string][]string{"app": nil, "web": {"app"}},
+		},
+		{
+			name: "namespace written outside of flux",
+			flux: &Flux{},
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{}, Flux: &Flux{Enabled: &disabled}},
+				"web": {Namespace: new(string)},
+			},
+			dependsOn: map[string][]string{"web": nil},
+		},
+		{
+			name: "resource outside of flux",
+			flux: &Flux{},
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{}},
+				"web": {Namespace: new(string), Flux: &Flux{Enabled: &disabled}},
+			},
+			err: "cluster dev: resource web is not applied by flux, but resource app creating its namespace shared is",
+		},
+		{
+			name: "creator depends on resource in namespace",
+			flux: &Flux{},
+			resources: map[string]*Resource{
+				"app": {Namespace: new(string), CreateNamespace: &CreateNamespace{}, Flux: &Flux{DependsOn: []string{"web"}}},
+				"web": {Namespace: new(string)},
+			},
+			err: "cluster dev: resource app creating namespace shared cannot depend on resource web in it",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, resource := range test.resources {
+				*resource.Namespace = "shared"
+			}
+			cluster := &Cluster{Flux: test.flux, Resources: test.resources}
+			cluster.load("dev")
+
+			err := cluster.validateNamespaces()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("validateNamespaces() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateNamespaces() error = %v", err)
+			}
+
+			for name, want := range test.dependsOn {
+				var dependsOn []string
+				for _, dependency := range cluster.fluxKustomization(settings, name).Spec.DependsOn {
+					dependsOn = append(dependsOn, dependency.Name)
+				}
+				if !reflect.DeepEqual(dependsOn, want) {
+					t.Errorf("%s dependsOn = %v, want %v", name, dependsOn, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceWriteNamespace(t *testing.T) {
+	settings := testSettings(t)
+	settings.Directories.Target = "clusters"
+	namespace := "team-a"
+
+	resource := &Resource{Namespace: &namespace, CreateNamespace: &CreateNamespace{
+		Labels:      map[string]string{"team": "[[[ .team ]]]", "tier-[[[ .tier ]]]": "true"},
+		Annotations: map[string]string{"owner": "[[[ .team ]]]@example.com"},
+	}}
+	resource.load("app")
+	err := os.MkdirAll(resource.pathCluster(settings, "dev"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = resource.writeNamespace(settings, Values{"team": "core", "tier": 1}, &ResourceReport{report: &Report{}}, "dev")
+	if err != nil {
+		t.Fatalf("writeNamespace() error = %v", err)
+	}
+
+	written, err := os.ReadFile(filepath.Join(resource.pathCluster(settings, "dev"), namespaceFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: v1
+kind: Namespace
+metadata:
+    name: team-a
+    labels:
+        team: core
+        tier-1: "true"
+    annotations:
+        owner: core@example.com
+`
+	if string(written) != want {
+		t.Errorf("namespace =\n%s\nwant\n%s", written, want)
+	}
+}
@@ -0,0 +1,173 @@
+package fkt
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Clusters extending a profile inherit its kustomization, values, resources,
+// flux and secrets settings. Profiles may extend other profiles.
+func (config *Config) resolveProfiles() error {
+	merge := mergeDefaults.override(&config.Settings.Merge)
+	resolved := map[string]*Cluster{}
+
+	var resolve func(name string, chain []string) (*Cluster, error)
+	resolve = func(name string, chain []string) (*Cluster, error) {
+		if profile, ok := resolved[name]; ok {
+			return profile, nil
+		}
+		for _, link := range chain {
+			if link == name {
+				return nil, fmt.Errorf("profile cycle: %s -> %s", strings.Join(chain, " -> "), name)
+			}
+		}
+
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("profile not found: %s", name)
+		}
+		if profile == nil {
+			profile = &Cluster{}
+		}
+
+		if profile.Extends != "" {
+			parent, err := resolve(profile.Extends, append(chain, name))
+			if err != nil {
+				return nil, err
+			}
+			profile = parent.inherit(profile, merge)
+		}
+
+		resolved[name] = profile
+		return profile, nil
+	}
+
+	for clusterPath, cluster := range config.Clusters {
+		if cluster == nil || cluster.Extends == "" {
+			continue
+		}
+
+		log.Debug("Cluster ", clusterPath, " extends profile ", cluster.Extends)
+		profile, err := resolve(cluster.Extends, nil)
+		if err != nil {
+			return fmt.Errorf("cannot resolve profile for cluster %s: %w", clusterPath, err)
+		}
+		inherited := profile.inherit(cluster, merge)
+		inherited.inheritName(clusterPath, cluster)
+		config.Clusters[clusterPath] = inherited
+	}
+
+	return nil
+}
+
+// Annotations inherited from a profile keep the name annotation of the
+// cluster, the stem of its path unless the cluster sets it.
+func (c *Cluster) inheritName(clusterPath string, cluster *Cluster) {
+	if c.Kustomization == nil || c.Kustomization.CommonAnnotations == nil {
+		return
+	}
+	if cluster.Kustomization != nil {
+		if _, ok := cluster.Kustomization.CommonAnnotations["name"]; ok {
+			return
+		}
+	}
+
+	_, name := filepath.Split(clusterPath)
+	c.Kustomization.CommonAnnotations["name"] = name
+}
+
+// Returns a new cluster with the properties of child overriding those of c.
+func (c *Cluster) inherit(child *Cluster, merge Merge) *Cluster {
+	cluster := &Cluster{
+		Managed:      c.Managed,
+		Merge:        c.Merge,
+		Flux:         c.Flux.override(child.Flux),
+		AgePublicKey: c.AgePublicKey,
+		Sops:         c.Sops,
+		Extends:      child.Extends,
+		Secrets:      c.Secrets,
+		Encryption:   append(slices.Clone(child.Encryption), c.Encryption...),
+	}
+
+	if child.Managed != nil {
+		cluster.Managed = child.Managed
+	}
+	if child.Merge != nil {
+		cluster.Merge = child.Merge
+	}
+	// The age public key is shorthand for the sops block, either replaces both.
+	if child.AgePublicKey != "" || child.Sops != nil {
+		cluster.AgePublicKey = child.AgePublicKey
+		cluster.Sops = child.Sops
+	}
+	if child.Secrets != nil {
+		cluster.Secrets = child.Secrets
+	}
+
+	cluster.Kustomization = c.Kustomization.inherit(child.Kustomization)
+
+	valuesMerge := merge.override(cluster.Merge)
+	values := Values{}
+	for _, v := range []*Values{c.Values, child.Values} {
+		if v != nil {
+			values = valuesMerge.values(values, *v)
+		}
+	}
+	cluster.Values = &values
+
+	if c.Resources != nil || child.Resources != nil {
+		cluster.Resources = map[string]*Resource{}
+	}
+	for resourceName, resource := range c.Resources {
+		cluster.Resources[resourceName] = (&Resource{}).inherit(resource, valuesMerge)
+	}
+	for resourceName, resource := range child.Resources {
+		parent, ok := cluster.Resources[resourceName]
+		if !ok {
+			parent = &Resource{}
+		}
+		cluster.Resources[resourceName] = parent.inherit(resource, valuesMerge)
+	}
+
+	return cluster
+}
+
+// Returns a new resource with the properties of child overriding those of r.
+func (r *Resource) inherit(child *Resource, merge Merge) *Resource {
+	resource := *r
+	if child == nil {
+		return &resource
+	}
+
+	// A template, chart or helm release of the child replaces any of the parent.
+	if child.Template != nil || child.Chart != nil || child.Helm != nil {
+		resource.Template = child.Template
+		resource.Chart = child.Chart
+		resource.Helm = child.Helm
+	}
+	if child.Namespace != nil {
+		resource.Namespace = child.Namespace
+	}
+	if child.Managed != nil {
+		resource.Managed = child.Managed
+	}
+	if child.Merge != nil {
+		resource.Merge = child.Merge
+	}
+	if child.Secrets != nil {
+		resource.Secrets = child.Secrets
+	}
+	if child.CreateNamespace != nil {
+		resource.CreateNamespace = child.CreateNamespace
+	}
+	resource.Kustomization = r.Kustomization.inherit(child.Kustomization)
+	resource.Encryption = append(slices.Clone(child.Encryption), r.Encryption...)
+	resource.Flux = r.Flux.override(child.Flux)
+	resource.Values = merge.override(resource.Merge).values(r.Values, child.Values)
+
+	return &resource
+}
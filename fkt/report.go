@@ -0,0 +1,207 @@
+package fkt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	utils "github.com/clingclangclick/fkt/utils"
+)
+
+type FileAction string
+
+const (
+	FileWritten   FileAction = "written"
+	FileUnchanged FileAction = "unchanged"
+	FileRemoved   FileAction = "removed"
+)
+
+type ResourceStatus string
+
+const (
+	ResourceRendered   ResourceStatus = "rendered"
+	ResourceUnmanaged  ResourceStatus = "unmanaged"
+	ResourceUnselected ResourceStatus = "unselected"
+)
+
+type Report struct {
+	DryRun     bool                      `json:"dry_run"`
+	Started    time.Time                 `json:"started"`
+	DurationMS int64                     `json:"duration_ms"`
+	Error      string                    `json:"error,omitempty"`
+	Warnings   []string                  `json:"warnings"`
+	Clusters   map[string]*ClusterReport `json:"clusters"`
+	base       string
+	mu         sync.Mutex
+}
+
+type ClusterReport struct {
+	DurationMS int64                      `json:"duration_ms"`
+	Error      string                     `json:"error,omitempty"`
+	Files      []FileReport               `json:"files"`
+	Warnings   []string                   `json:"warnings"`
+	Resources  map[string]*ResourceReport `json:"resources"`
+	report     *Report
+	started    time.Time
+}
+
+type ResourceReport struct {
+	Status     ResourceStatus `json:"status"`
+	Template   string         `json:"template,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+	Files      []FileReport   `json:"files"`
+	Secrets    []string       `json:"secrets_encrypted"`
+	Warnings   []string       `json:"warnings"`
+	report     *Report
+	started    time.Time
+}
+
+type FileReport struct {
+	Path   string     `json:"path"`
+	Action FileAction `json:"action"`
+}
+
+func newReport(settings *Settings) *Report {
+	return &Report{
+		DryRun:   settings.DryRun,
+		Started:  time.Now().UTC(),
+		Warnings: []string{},
+		Clusters: map[string]*ClusterReport{},
+		base:     settings.Directories.baseDirectory,
+	}
+}
+
+func (config *Config) Report() *Report {
+	return config.report
+}
+
+func (report *Report) Write(path string, err error) error {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	report.DurationMS = time.Since(report.Started).Milliseconds()
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal report: %w", err)
+	}
+
+	err = os.WriteFile(path, append(reportJSON, '\n'), 0666)
+	if err != nil {
+		return fmt.Errorf("cannot write report: %w", err)
+	}
+
+	return nil
+}
+
+func (report *Report) warn(args ...interface{}) {
+	log.Warn(args...)
+
+	report.mu.Lock()
+	defer report.mu.Unlock()
+	report.Warnings = append(report.Warnings, fmt.Sprint(args...))
+}
+
+func (report *Report) rel(path string) string {
+	relPath, err := filepath.Rel(report.base, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
+
+func (report *Report) cluster(clusterPath string) *ClusterReport {
+	report.mu.Lock()
+	defer report.mu.Unlock()
+
+	clusterReport := &ClusterReport{
+		Files:     []FileReport{},
+		Warnings:  []string{},
+		Resources: map[string]*ResourceReport{},
+		report:    report,
+		started:   time.Now(),
+	}
+	report.Clusters[clusterPath] = clusterReport
+
+	return clusterReport
+}
+
+func (clusterReport *ClusterReport) done(err error) {
+	clusterReport.report.mu.Lock()
+	defer clusterReport.report.mu.Unlock()
+
+	clusterReport.DurationMS = time.Since(clusterReport.started).Milliseconds()
+	if err != nil {
+		clusterReport.Error = err.Error()
+	}
+}
+
+func (clusterReport *ClusterReport) file(path string, action FileAction) {
+	clusterReport.Files = append(clusterReport.Files, FileReport{
+		Path:   clusterReport.report.rel(path),
+		Action: action,
+	})
+}
+
+func (clusterReport *ClusterReport) warn(args ...interface{}) {
+	log.Warn(args...)
+	clusterReport.Warnings = append(clusterReport.Warnings, fmt.Sprint(args...))
+}
+
+func (clusterReport *ClusterReport) resource(resourceName string, status ResourceStatus) *ResourceReport {
+	resourceReport := &ResourceReport{
+		Status:   status,
+		Files:    []FileReport{},
+		Secrets:  []string{},
+		Warnings: []string{},
+		report:   clusterReport.report,
+		started:  time.Now(),
+	}
+	clusterReport.Resources[resourceName] = resourceReport
+
+	return resourceReport
+}
+
+func (resourceReport *ResourceReport) done() {
+	resourceReport.DurationMS = time.Since(resourceReport.started).Milliseconds()
+}
+
+func (resourceReport *ResourceReport) file(path string, action FileAction) {
+	resourceReport.Files = append(resourceReport.Files, FileReport{
+		Path:   resourceReport.report.rel(path),
+		Action: action,
+	})
+}
+
+func (resourceReport *ResourceReport) secret(path string) {
+	resourceReport.Secrets = append(resourceReport.Secrets, resourceReport.report.rel(path))
+}
+
+func (resourceReport *ResourceReport) warn(args ...interface{}) {
+	log.Warn(args...)
+	resourceReport.Warnings = append(resourceReport.Warnings, fmt.Sprint(args...))
+}
+
+// Writes b to path unless the contents are unchanged.
+func writeFile(path string, b []byte, dryRun bool) (FileAction, error) {
+	existing, err := utils.ReadFile(path)
+	if err == nil && bytes.Equal(existing, b) {
+		return FileUnchanged, nil
+	}
+
+	err = utils.WriteFile(path, b, 0666, dryRun)
+	if err != nil {
+		return "", err
+	}
+
+	return FileWritten, nil
+}
@@ -1,9 +1,11 @@
 package fkt
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 
 	log "github.com/sirupsen/logrus"
 
@@ -11,18 +13,39 @@ import (
 )
 
 type Resource struct {
-	Template  *string `yaml:"template"`
-	Namespace *string `yaml:"namespace"`
-	Values    Values  `yaml:"values,flow"`
-	Managed   *bool   `yaml:"managed"`
-	Name      string
+	Template        *string          `yaml:"template"`
+	Chart           *Chart           `yaml:"chart"`
+	Helm            *Helm            `yaml:"helm"`
+	Namespace       *string          `yaml:"namespace"`
+	Values          Values           `yaml:"values,flow"`
+	Merge           *Merge           `yaml:"merge"`
+	Flux            *Flux            `yaml:"flux"`
+	Managed         *bool            `yaml:"managed"`
+	Secrets         *SecretsConfig   `yaml:"secrets"`
+	Encryption      []EncryptionRule `yaml:"encryption"`
+	Kustomization   *Kustomization   `yaml:"kustomization"`
+	CreateNamespace *CreateNamespace `yaml:"create_namespace"`
+	Name            string
+
+	writesNamespace bool
+	namespaceWriter string
 }
 
+const resourceBaseDirectory = "base"
+
 func (r *Resource) config() Values {
 	config := make(Values)
 
 	config["name"] = r.Name
-	config["template"] = *r.Template
+	if r.Template != nil {
+		config["template"] = *r.Template
+	}
+	if r.Chart != nil {
+		config["chart"] = r.Chart.Path
+	}
+	if r.Helm != nil {
+		config["helm"] = r.Helm.Chart
+	}
 	config["namespace"] = *r.Namespace
 
 	return config
@@ -43,7 +66,7 @@ func (r *Resource) load(name string) {
 		r.Namespace = &name
 	}
 
-	if r.Template == nil {
+	if r.Template == nil && r.Chart == nil && r.Helm == nil {
 		log.Debug("Resource template path unset, setting to resource name")
 		r.Template = &name
 	}
@@ -53,20 +76,79 @@ func (r *Resource) load(name string) {
 	}
 }
 
+// Template directory or chart the resource is rendered from.
+func (r *Resource) source() string {
+	if r.Chart != nil {
+		return r.Chart.Path
+	}
+	if r.Helm != nil {
+		return r.Helm.Chart
+	}
+
+	return *r.Template
+}
+
 func (r *Resource) pathCluster(settings *Settings, clusterPath string) string {
 	return filepath.Join(settings.Directories.baseDirectory, settings.Directories.Target, clusterPath, r.Name)
 }
 
+// Resources with a kustomization or created namespace are an overlay of the
+// rendered source.
+func (r *Resource) overlaid() bool {
+	return r.Kustomization != nil || r.CreateNamespace.enabled()
+}
+
+// Directory the source is rendered into, base of the overlay when the resource
+// is overlaid.
+func (r *Resource) pathRendered(settings *Settings, clusterPath string) string {
+	if r.overlaid() {
+		return filepath.Join(r.pathCluster(settings, clusterPath), resourceBaseDirectory)
+	}
+
+	return r.pathCluster(settings, clusterPath)
+}
+
 func (r *Resource) pathTemplates(settings *Settings) string {
 	return filepath.Join(settings.Directories.baseDirectory, settings.Directories.Templates, *r.Template)
 }
 
-func (r *Resource) process(settings *Settings, values Values, secrets *Secrets, clusterPath string, subPaths ...string) error {
+func (r *Resource) process(settings *Settings, values Values, secrets *Secrets, report *ResourceReport, clusterPath string) error {
 	if !*r.Managed {
 		log.Info("Unmanaged, skipping templates for resource: ", r.Name)
 		return nil
 	}
 
+	if r.overlaid() {
+		err := r.pruneOverlay(settings, report, clusterPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch {
+	case r.Chart != nil:
+		err = r.processChart(settings, values, secrets, report, clusterPath)
+	case r.Helm != nil:
+		err = r.processHelm(settings, values, report, clusterPath)
+	default:
+		err = r.processTemplate(settings, values, secrets, report, clusterPath)
+	}
+	if err != nil || !r.overlaid() {
+		return err
+	}
+
+	if r.CreateNamespace.enabled() && r.writesNamespace {
+		err = r.writeNamespace(settings, values, report, clusterPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.writeKustomization(settings, values, report, clusterPath)
+}
+
+func (r *Resource) processTemplate(settings *Settings, values Values, secrets *Secrets, report *ResourceReport, clusterPath string, subPaths ...string) error {
 	var subPath string
 	if len(subPaths) > 0 {
 		var subPathSlice []string
@@ -86,18 +168,21 @@ func (r *Resource) process(settings *Settings, values Values, secrets *Secrets,
 	}
 
 	if !utils.ContainsKustomization(r.pathTemplates(settings)) {
-		log.Warn("kustomization file does not exist in: ", templatePath)
+		report.warn("kustomization file does not exist in: ", templatePath)
 		return nil
 	}
 
-	clusterResourcePath := filepath.Join(r.pathCluster(settings, clusterPath), subPath)
+	clusterResourcePath := filepath.Join(r.pathRendered(settings, clusterPath), subPath)
 	log.Debug("Cluster resource path: ", utils.RelWD(clusterResourcePath))
 
 	clusterResourcePathExists, _ := utils.IsDir(clusterResourcePath)
 	if clusterResourcePathExists {
-		err := utils.RemoveExtraFilesAndDirectories(clusterResourcePath, templatePath, settings.DryRun)
+		removed, err := utils.RemoveExtraFilesAndDirectories(clusterResourcePath, templatePath, settings.DryRun)
+		for _, removedPath := range removed {
+			report.file(removedPath, FileRemoved)
+		}
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 
@@ -112,6 +197,7 @@ func (r *Resource) process(settings *Settings, values Values, secrets *Secrets,
 		return err
 	}
 
+	var templateErrors TemplateErrors
 	for _, entry := range entries {
 		resourceEntryPath := filepath.Join(templatePath, entry)
 		dt, err := utils.IsDir(resourceEntryPath)
@@ -124,22 +210,74 @@ func (r *Resource) process(settings *Settings, values Values, secrets *Secrets,
 			return err
 		}
 		if !dt {
-			err := values.template(resourceEntryPath, targetEntryPath, settings, secrets)
+			err := values.template(resourceEntryPath, targetEntryPath, settings, secrets, report)
+			var templateError *TemplateError
+			if errors.As(err, &templateError) {
+				templateError.Cluster = clusterPath
+				templateError.Resource = r.Name
+				templateErrors = append(templateErrors, templateError)
+				continue
+			}
 			if err != nil {
 				return err
 			}
 		} else {
-			err = r.process(settings, values, secrets, clusterPath, entry)
+			err = r.processTemplate(settings, values, secrets, report, clusterPath, append(slices.Clone(subPaths), entry)...)
+			var subPathTemplateErrors TemplateErrors
+			if errors.As(err, &subPathTemplateErrors) {
+				templateErrors = append(templateErrors, subPathTemplateErrors...)
+				continue
+			}
 			if err != nil {
 				return err
 			}
 		}
 	}
+	if len(templateErrors) > 0 {
+		return templateErrors
+	}
 
 	return nil
 }
 
 func (r *Resource) validate(settings *Settings, name string) error {
+	sources := 0
+	for _, set := range []bool{r.Template != nil, r.Chart != nil, r.Helm != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("resource %s: template, chart and helm are mutually exclusive", name)
+	}
+
+	err := r.validateNamespace(name)
+	if err != nil {
+		return err
+	}
+
+	if r.Chart != nil {
+		err := r.Chart.validate(settings)
+		if err != nil {
+			return fmt.Errorf("resource chart validation failed for: %s; %w", name, err)
+		}
+		if *r.Managed {
+			_, err := helmBinary()
+			if err != nil {
+				return fmt.Errorf("resource chart validation failed for: %s; %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	if r.Helm != nil {
+		err := r.Helm.validate()
+		if err != nil {
+			return fmt.Errorf("resource helm validation failed for: %s; %w", name, err)
+		}
+		return nil
+	}
+
 	if *r.Managed {
 		path := filepath.Join(settings.pathTemplates(), *r.Template)
 		_, err := utils.IsDir(path)
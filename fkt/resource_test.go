@@ -0,0 +1,50 @@
+package fkt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResourceProcessNestedTemplates(t *testing.T) {
+	settings := testSettings(t)
+	settings.Directories.Target = "clusters"
+
+	files := map[string]string{
+		"app/kustomization.yaml":              "resources:\n  - one/two/configmap.yaml\n",
+		"app/one/namespace.yaml":              "name: [[[ .name ]]]\n",
+		"app/one/two/configmap.yaml":          "name: [[[ .name ]]]\n",
+		"app/one/two/three/extra/values.yaml": "name: [[[ .name ]]]\n",
+	}
+	for path, contents := range files {
+		path = filepath.Join(settings.pathTemplates(), path)
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template := "app"
+	resource := &Resource{Template: &template}
+	resource.load("app")
+
+	err := resource.processTemplate(settings, Values{"name": "app"}, &Secrets{}, &ResourceReport{report: &Report{}}, "dev")
+	if err != nil {
+		t.Fatalf("processTemplate() error = %v", err)
+	}
+
+	for path := range files {
+		rendered, err := os.ReadFile(filepath.Join(settings.pathTargets(), "dev", path))
+		if err != nil {
+			t.Errorf("not rendered: %v", err)
+			continue
+		}
+		if path != "app/kustomization.yaml" && string(rendered) != "name: app\n" {
+			t.Errorf("%s = %q, want %q", path, rendered, "name: app\n")
+		}
+	}
+}
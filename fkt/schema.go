@@ -0,0 +1,297 @@
+package fkt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const schemaID = "https://raw.githubusercontent.com/clingclangclick/fkt/main/fkt.schema.json"
+
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 schemaTypes        `json:"type,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	closed               bool
+}
+
+type schemaTypes []string
+
+// A single type is marshalled as a string.
+func (t schemaTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	if !s.closed {
+		return json.Marshal((*schema)(s))
+	}
+
+	// Objects with fixed properties reject unknown fields.
+	return json.Marshal(struct {
+		*schema
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{(*schema)(s), false})
+}
+
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeOf(LogLevel("")): {
+		string(TraceLevel), string(DebugLevel), string(InfoLevel), string(WarnLevel), string(ErrorLevel), string(PanicLevel),
+	},
+	reflect.TypeOf(LogFormat("")):     {string(ConsoleFormat), string(JsonFormat)},
+	reflect.TypeOf(MergeStrategy("")): {string(OverwriteMerge), string(DeepMerge)},
+	reflect.TypeOf(ListStrategy("")):  {string(ReplaceLists), string(AppendLists), string(MergeLists)},
+	reflect.TypeOf(MissingKey("")):    {string(DefaultMissingKey), string(ZeroMissingKey), string(ErrorMissingKey)},
+}
+
+// JSON Schema of the configuration file format.
+func JSONSchema() *Schema {
+	defs := map[string]*Schema{}
+	schema := structSchema(reflect.TypeOf(Config{}), defs)
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.ID = schemaID
+	schema.Title = "fkt configuration"
+	schema.Defs = defs
+
+	return schema
+}
+
+func schemaFor(t reflect.Type, defs map[string]*Schema) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	schema := &Schema{}
+	switch {
+	case schemaEnums[t] != nil:
+		schema.Type = schemaTypes{"string"}
+		schema.Enum = schemaEnums[t]
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil
+			defs[t.Name()] = structSchema(t, defs)
+		}
+		// Struct definitions are nullable, an empty mapping value is unset.
+		return &Schema{Ref: "#/$defs/" + t.Name()}
+	case t.Kind() == reflect.Struct:
+		schema = structSchema(t, defs)
+	case t.Kind() == reflect.Map:
+		schema.Type = schemaTypes{"object", "null"}
+		if t.Elem().Kind() != reflect.Interface {
+			schema.AdditionalProperties = schemaFor(t.Elem(), defs)
+		}
+	case t.Kind() == reflect.Slice:
+		schema.Type = schemaTypes{"array", "null"}
+		if t.Elem().Kind() != reflect.Interface {
+			schema.Items = schemaFor(t.Elem(), defs)
+		}
+	case t.Kind() == reflect.String:
+		schema.Type = schemaTypes{"string"}
+	case t.Kind() == reflect.Bool:
+		schema.Type = schemaTypes{"boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema.Type = schemaTypes{"integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema.Type = schemaTypes{"number"}
+	}
+
+	if nullable && len(schema.Type) == 1 {
+		schema.Type = append(schema.Type, "null")
+	}
+
+	return schema
+}
+
+func structSchema(t reflect.Type, defs map[string]*Schema) *Schema {
+	schema := &Schema{
+		Type:       schemaTypes{"object", "null"},
+		Properties: map[string]*Schema{},
+		closed:     true,
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if field.Anonymous && options == "inline" {
+			for property, propertySchema := range structSchema(field.Type, defs).Properties {
+				schema.Properties[property] = propertySchema
+			}
+			continue
+		}
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		schema.Properties[name] = schemaFor(field.Type, defs)
+	}
+
+	return schema
+}
+
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", e.File, e.Line, e.Column, e.Path, e.Message)
+}
+
+type schemaValidator struct {
+	file   string
+	defs   map[string]*Schema
+	errors []error
+}
+
+// Validates a parsed configuration file against the schema, all errors are
+// returned joined.
+func validateSchema(file string, node *yaml.Node) error {
+	schema := JSONSchema()
+	validator := &schemaValidator{
+		file: file,
+		defs: schema.Defs,
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		validator.validate(node.Content[0], schema, "$")
+	}
+
+	return errors.Join(validator.errors...)
+}
+
+func (v *schemaValidator) fail(node *yaml.Node, path, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ConfigError{
+		File:    v.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *schemaValidator) validate(node *yaml.Node, schema *Schema, path string) {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if schema.Ref != "" {
+		schema = v.defs[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+	}
+	if len(schema.Type) == 0 {
+		return
+	}
+
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		if !slices.Contains(schema.Type, "null") {
+			v.fail(node, path, "expected %s, got null", schema.Type[0])
+		}
+		return
+	}
+
+	switch schema.Type[0] {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			v.fail(node, path, "expected object, got %s", nodeKind(node))
+			return
+		}
+		v.object(node, schema, path)
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			v.fail(node, path, "expected array, got %s", nodeKind(node))
+			return
+		}
+		if schema.Items == nil {
+			return
+		}
+		for i, item := range node.Content {
+			v.validate(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	default:
+		expected := map[string]string{
+			"string":  "!!str",
+			"boolean": "!!bool",
+			"integer": "!!int",
+			"number":  "!!float",
+		}[schema.Type[0]]
+		tag := node.ShortTag()
+		if node.Kind != yaml.ScalarNode || (tag != expected && !(expected == "!!float" && tag == "!!int")) {
+			v.fail(node, path, "expected %s, got %s", schema.Type[0], nodeKind(node))
+			return
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, node.Value) {
+			v.fail(node, path, "%q is not one of %s", node.Value, strings.Join(schema.Enum, ", "))
+		}
+	}
+}
+
+func (v *schemaValidator) object(node *yaml.Node, schema *Schema, path string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		// Merge keys splice one or a list of mappings into this one.
+		if key.ShortTag() == "!!merge" {
+			merged := []*yaml.Node{value}
+			for value.Kind == yaml.AliasNode {
+				value = value.Alias
+			}
+			if value.Kind == yaml.SequenceNode {
+				merged = value.Content
+			}
+			for _, m := range merged {
+				v.validate(m, schema, path)
+			}
+			continue
+		}
+
+		keyPath := path + "." + key.Value
+		if property, ok := schema.Properties[key.Value]; ok {
+			v.validate(value, property, keyPath)
+			continue
+		}
+		if schema.AdditionalProperties != nil {
+			v.validate(value, schema.AdditionalProperties, keyPath)
+			continue
+		}
+		if schema.closed {
+			v.fail(key, path, "unknown field %q", key.Value)
+		}
+	}
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.ShortTag() {
+	case "!!str":
+		return fmt.Sprintf("string %q", node.Value)
+	case "!!bool":
+		return "boolean " + node.Value
+	case "!!int", "!!float":
+		return "number " + node.Value
+	}
+	return node.ShortTag() + " " + node.Value
+}
@@ -1,54 +1,297 @@
 package fkt
 
-//
-// Secrets last encode date
-// Template file with secret yaml last update date FS
-// Save cluster secrets yaml doc from existing if exists and use if
-//    secrets.yaml last update date <= generated file date
-//    ~template file update date is <= generated file date
-//
-// Store resource file dates prior to removal
-// ~Get secrets.yaml last update date~
-
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"time"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
 
 	utils "github.com/clingclangclick/fkt/utils"
 	decrypt "github.com/getsops/sops/v3/decrypt"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
+
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
-func (s *Secrets) read(path string) error {
-	log.Info("Decrypting secrets from ", path)
+type SecretsConfig struct {
+	SecretsFile string `yaml:"file"`
+}
+
+type Secrets struct {
+	values     Values
+	keys       *Sops
+	encryption []EncryptionRule
+}
+
+// Decrypted secrets files shared by the clusters of a run, keyed by path and
+// content hash so each file is decrypted at most once.
+type secretsCache struct {
+	mu      sync.Mutex
+	entries map[string]*secretsCacheEntry
+}
+
+type secretsCacheEntry struct {
+	once   sync.Once
+	values Values
+	err    error
+}
+
+// Decryption errors of secrets files are cached with the file, they are
+// reported once for the run instead of by every cluster using the file.
+var errSecretsDecrypt = errors.New("cannot decrypt secrets file")
+
+type secretsDecryptError struct {
+	path string
+	err  error
+}
+
+func (e *secretsDecryptError) Error() string {
+	return fmt.Sprintf("%s: %s; %s", errSecretsDecrypt, e.path, e.err)
+}
+
+func (e *secretsDecryptError) Unwrap() []error {
+	return []error{errSecretsDecrypt, e.err}
+}
+
+var decryptData = decrypt.Data
+
+func newSecretsCache() *secretsCache {
+	return &secretsCache{
+		entries: map[string]*secretsCacheEntry{},
+	}
+}
+
+func (cache *secretsCache) entry(path string, sopsBytes []byte) *secretsCacheEntry {
+	hash := sha256.Sum256(sopsBytes)
+	key := path + "@" + hex.EncodeToString(hash[:])
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok {
+		entry = &secretsCacheEntry{}
+		cache.entries[key] = entry
+	}
+
+	return entry
+}
+
+// Decrypted values must not be modified, they are shared between clusters.
+func (cache *secretsCache) decrypt(path string) (Values, error) {
+	sopsBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := cache.entry(path, sopsBytes)
+	entry.once.Do(func() {
+		log.Info("Decrypting secrets from ", path)
+
+		contents, err := decryptData(sopsBytes, "yaml")
+		if err == nil {
+			err = yaml.Unmarshal(contents, &entry.values)
+		}
+		if err != nil {
+			log.Error("Cannot decrypt secrets ", path, ": ", err)
+			entry.err = &secretsDecryptError{path: path, err: err}
+		}
+	})
+
+	return entry.values, entry.err
+}
+
+func (s *SecretsConfig) file() string {
+	if s == nil {
+		return ""
+	}
+	return s.SecretsFile
+}
+
+func (s *SecretsConfig) path(settings *Settings) string {
+	return filepath.Join(settings.Directories.baseDirectory, s.file())
+}
+
+// Secrets files are only decrypted for clusters with sops keys.
+func (s *SecretsConfig) validate(settings *Settings, keys *Sops) error {
+	if s.file() == "" {
+		return nil
+	}
+	if keys == nil {
+		return fmt.Errorf("secrets file set without sops keys or an age public key: %s", s.file())
+	}
+
+	isFile, err := utils.IsFile(s.path(settings))
+	if err != nil {
+		return fmt.Errorf("secrets file validation failed: %s; %w", s.file(), err)
+	}
+	if !isFile {
+		return fmt.Errorf("secrets file is not a file: %s", s.file())
+	}
+
+	return nil
+}
+
+// Returns a copy of s with the secrets decrypted from path merged over its
+// values.
+func (s Secrets) layer(cache *secretsCache, path string, merge Merge) (Secrets, error) {
+	values, err := cache.decrypt(path)
+	if err != nil {
+		return s, err
+	}
+
+	s.values = merge.values(s.values, values)
+
+	return s, nil
+}
+
+const redactedSecret = "redacted"
+
+// Returns a copy of the secrets with the same structure, strings replaced by
+// a placeholder and other values by their zero value, for rendering documents
+// before their encryption rule is known.
+func redactSecrets(value interface{}) interface{} {
+	switch value := value.(type) {
+	case Values:
+		return Values(redactSecrets(map[string]interface{}(value)).(map[string]interface{}))
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			redacted[key] = redactSecrets(child)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for index, child := range value {
+			redacted[index] = redactSecrets(child)
+		}
+		return redacted
+	case string:
+		return redactedSecret
+	case nil:
+		return nil
+	}
+
+	return reflect.Zero(reflect.TypeOf(value)).Interface()
+}
+
+type encryptedSecret struct {
+	encrypted  []byte
+	plaintext  interface{}
+	recipients []string
+	rule       EncryptionRule
+}
+
+func objectIdentity(document []byte) (string, error) {
+	object := struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+	}{}
+
+	err := yaml.Unmarshal(document, &object)
+	if err != nil {
+		return "", err
+	}
+
+	return object.Kind + "/" + object.Metadata.Namespace + "/" + object.Metadata.Name, nil
+}
+
+// Decrypts the SOPS encrypted documents of an existing target file, keyed by
+// object identity. Documents that cannot be decrypted are skipped and will be
+// encrypted again.
+func readEncryptedSecrets(targetPath string) (map[string]*encryptedSecret, error) {
+	encryptedSecrets := map[string]*encryptedSecret{}
+
+	fileBytes, err := utils.ReadFile(targetPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return encryptedSecrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	secretsFileExists, err := utils.IsFile(path)
-	if secretsFileExists && err == nil {
-		sopsBytes, err := os.ReadFile(path)
+	warned := false
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(fileBytes)))
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
-		lastModified, err := utils.SOPSLastModified(sopsBytes)
+
+		tree, err := (&sopsyaml.Store{}).LoadEncryptedFile(document)
+		if err != nil {
+			continue
+		}
+
+		plaintext, err := decrypt.Data(document, "yaml")
 		if err != nil {
-			return err
+			if !warned {
+				log.Warn("Cannot decrypt existing secrets in ", targetPath, ", encrypting again, the decryption key is required to keep ciphertext: ", err)
+				warned = true
+			}
+			continue
 		}
-		s.lastModified = &lastModified
 
-		contents, err := decrypt.File(path, "yaml")
+		identity, err := objectIdentity(plaintext)
 		if err != nil {
-			return err
+			continue
 		}
 
-		err = yaml.Unmarshal(contents, &s.values)
-		return err
-	} else {
-		return err
+		secret := &encryptedSecret{
+			encrypted:  document,
+			recipients: recipients(tree.Metadata.KeyGroups, tree.Metadata.ShamirThreshold),
+			rule: EncryptionRule{
+				EncryptedRegex:   tree.Metadata.EncryptedRegex,
+				EncryptedSuffix:  tree.Metadata.EncryptedSuffix,
+				UnencryptedRegex: tree.Metadata.UnencryptedRegex,
+			},
+		}
+		err = yaml.Unmarshal(plaintext, &secret.plaintext)
+		if err != nil {
+			continue
+		}
+		encryptedSecrets[identity] = secret
 	}
+
+	return encryptedSecrets, nil
 }
 
-type Secrets struct {
-	values       Values
-	ageKey       string
-	lastModified *time.Time
+// The existing ciphertext is kept when the rendered plaintext, the recipients
+// and the encrypted values are unchanged.
+func (secret *encryptedSecret) unchanged(rendered string, keys *Sops, rule *EncryptionRule) bool {
+	var plaintext interface{}
+	err := yaml.Unmarshal([]byte(rendered), &plaintext)
+	if err != nil || !reflect.DeepEqual(plaintext, secret.plaintext) {
+		return false
+	}
+
+	if secret.rule.EncryptedRegex != rule.EncryptedRegex ||
+		secret.rule.EncryptedSuffix != rule.EncryptedSuffix ||
+		secret.rule.UnencryptedRegex != rule.UnencryptedRegex {
+		return false
+	}
+
+	keyGroups, err := keys.keyGroups()
+	if err != nil {
+		return false
+	}
+
+	return slices.Equal(recipients(keyGroups, keys.ShamirThreshold), secret.recipients)
 }
@@ -0,0 +1,157 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEncryptDocumentKeepsCiphertext(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity)
+
+	keys := &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}}
+	targetPath := filepath.Join(t.TempDir(), "secret.yaml")
+	report := &ResourceReport{report: &Report{}}
+
+	secrets := &Secrets{keys: keys}
+	encrypted, err := secrets.encryptDocument(testSecret, &defaultEncryptionRule, map[string]*encryptedSecret{}, targetPath, report)
+	if err != nil {
+		t.Fatalf("encryptDocument() error = %v", err)
+	}
+
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\ndata:\n  mode: a\n"
+	err = os.WriteFile(targetPath, []byte(configMap+"---\n"+encrypted), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		document  string
+		keys      *Sops
+		rule      EncryptionRule
+		identity  string
+		unchanged bool
+	}{
+		{
+			name:      "unchanged",
+			document:  testSecret,
+			keys:      keys,
+			rule:      defaultEncryptionRule,
+			unchanged: true,
+		},
+		{
+			name:     "plaintext changed",
+			document: strings.Replace(testSecret, "hunter2", "hunter3", 1),
+			keys:     keys,
+			rule:     defaultEncryptionRule,
+		},
+		{
+			name:     "recipients changed",
+			document: testSecret,
+			keys:     &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient, testAgeRecipientOther}}},
+			rule:     defaultEncryptionRule,
+		},
+		{
+			name:     "rule changed",
+			document: testSecret,
+			keys:     keys,
+			rule:     EncryptionRule{EncryptedRegex: "^password$"},
+		},
+		{
+			name:     "decryption key missing",
+			document: testSecret,
+			keys:     keys,
+			rule:     defaultEncryptionRule,
+			identity: testAgeIdentityOther,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.identity != "" {
+				testAgeIdentities(t, test.identity)
+			}
+
+			existingSecrets, err := readEncryptedSecrets(targetPath)
+			if err != nil {
+				t.Fatalf("readEncryptedSecrets() error = %v", err)
+			}
+
+			report := &ResourceReport{report: &Report{}}
+			secrets := &Secrets{keys: test.keys}
+			got, err := secrets.encryptDocument(test.document, &test.rule, existingSecrets, targetPath, report)
+			if err != nil {
+				t.Fatalf("encryptDocument() error = %v", err)
+			}
+
+			if unchanged := got == encrypted; unchanged != test.unchanged {
+				t.Errorf("ciphertext kept = %t, want %t", unchanged, test.unchanged)
+			}
+			if encryptedAgain := len(report.Secrets) > 0; encryptedAgain == test.unchanged {
+				t.Errorf("secrets encrypted = %v, want encrypted %t", report.Secrets, !test.unchanged)
+			}
+		})
+	}
+}
+
+func TestProcessSecretsDecryptedOnce(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity)
+
+	settings := testSettings(t)
+	global, err := encrypt("token: global\n", &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}}, &EncryptionRule{})
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	files := map[string][]byte{
+		"global.yaml": global,
+		"broken.yaml": []byte("token: not encrypted\n"),
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(settings.Directories.baseDirectory, name), contents, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var decryptionsMutex sync.Mutex
+	decryptions := map[string]int{}
+	original := decryptData
+	t.Cleanup(func() { decryptData = original })
+	decryptData = func(data []byte, format string) ([]byte, error) {
+		decryptionsMutex.Lock()
+		decryptions[string(data)]++
+		decryptionsMutex.Unlock()
+		return original(data, format)
+	}
+
+	config := &Config{
+		Settings: settings,
+		Secrets:  SecretsConfig{SecretsFile: "global.yaml"},
+		Clusters: map[string]*Cluster{},
+	}
+	for index := 0; index < 8; index++ {
+		config.Clusters[fmt.Sprintf("cluster-%d", index)] = &Cluster{
+			AgePublicKey: testAgeRecipient,
+			Secrets:      &SecretsConfig{SecretsFile: "broken.yaml"},
+		}
+	}
+
+	err = config.Process()
+	if !errors.Is(err, errSecretsDecrypt) {
+		t.Fatalf("Process() error = %v, want a secrets decryption error", err)
+	}
+	if count := strings.Count(err.Error(), errSecretsDecrypt.Error()); count != 1 {
+		t.Errorf("Process() reported %d decryption errors, want 1:\n%v", count, err)
+	}
+
+	for name, contents := range files {
+		if decryptions[string(contents)] != 1 {
+			t.Errorf("%s decrypted %d times, want 1", name, decryptions[string(contents)])
+		}
+	}
+}
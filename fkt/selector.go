@@ -0,0 +1,124 @@
+package fkt
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+type Selector struct {
+	Clusters  []string
+	Resources []string
+	Labels    []string
+}
+
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+type selector struct {
+	clusters     []string
+	resources    []string
+	requirements []labelRequirement
+}
+
+// Restricts Process to clusters matching every selector and, within them,
+// to the selected resources. Unselected resources are neither rendered nor
+// pruned.
+func (config *Config) Select(s Selector) error {
+	for _, pattern := range s.Clusters {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid cluster pattern: %s; %w", pattern, err)
+		}
+	}
+
+	var requirements []labelRequirement
+	for _, label := range s.Labels {
+		for _, expression := range strings.Split(label, ",") {
+			requirement, err := parseLabelRequirement(strings.TrimSpace(expression))
+			if err != nil {
+				return err
+			}
+			requirements = append(requirements, requirement)
+		}
+	}
+
+	config.selector = &selector{
+		clusters:     s.Clusters,
+		resources:    s.Resources,
+		requirements: requirements,
+	}
+
+	return nil
+}
+
+// Supports key=value, key==value, key!=value, key and !key
+func parseLabelRequirement(expression string) (labelRequirement, error) {
+	switch {
+	case expression == "":
+		return labelRequirement{}, fmt.Errorf("empty selector")
+	case strings.Contains(expression, "!="):
+		key, value, _ := strings.Cut(expression, "!=")
+		return labelRequirement{key: strings.TrimSpace(key), value: strings.TrimSpace(value), negate: true}, nil
+	case strings.Contains(expression, "=="):
+		key, value, _ := strings.Cut(expression, "==")
+		return labelRequirement{key: strings.TrimSpace(key), value: strings.TrimSpace(value)}, nil
+	case strings.Contains(expression, "="):
+		key, value, _ := strings.Cut(expression, "=")
+		return labelRequirement{key: strings.TrimSpace(key), value: strings.TrimSpace(value)}, nil
+	case strings.HasPrefix(expression, "!"):
+		return labelRequirement{key: strings.TrimSpace(expression[1:]), exists: true, negate: true}, nil
+	default:
+		return labelRequirement{key: expression, exists: true}, nil
+	}
+}
+
+func (r labelRequirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	if r.exists {
+		return ok != r.negate
+	}
+	if r.negate {
+		return !ok || value != r.value
+	}
+	return ok && value == r.value
+}
+
+func (s *selector) cluster(clusterPath string, c *Cluster) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.clusters) > 0 {
+		matched := false
+		for _, pattern := range s.clusters {
+			if ok, _ := path.Match(pattern, clusterPath); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	var labels map[string]string
+	if c.Kustomization != nil {
+		labels = c.Kustomization.CommonAnnotations
+	}
+	for _, requirement := range s.requirements {
+		if !requirement.matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *selector) resource(resourceName string) bool {
+	return s == nil || len(s.resources) == 0 || slices.Contains(s.resources, resourceName)
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
+	"gopkg.in/yaml.v3"
+
 	utils "github.com/clingclangclick/fkt/utils"
 )
 
@@ -16,6 +18,38 @@ var settingsDefaults = map[string]string{
 	"directory_templates": "templates",
 	"delimiter_left":      "[[[",
 	"delimiter_right":     "]]]",
+	"missing_key":         "default",
+}
+
+type MissingKey string
+
+const (
+	DefaultMissingKey MissingKey = "default"
+	ZeroMissingKey    MissingKey = "zero"
+	ErrorMissingKey   MissingKey = "error"
+)
+
+func (m *MissingKey) UnmarshalYAML(value *yaml.Node) error {
+	var missingKeyStr string
+	if err := value.Decode(&missingKeyStr); err != nil {
+		return err
+	}
+
+	if !MissingKey(missingKeyStr).valid() {
+		return fmt.Errorf("line %d: unknown missing key behaviour: %s", value.Line, missingKeyStr)
+	}
+	*m = MissingKey(missingKeyStr)
+
+	return nil
+}
+
+func (m MissingKey) valid() bool {
+	switch m {
+	case DefaultMissingKey, ZeroMissingKey, ErrorMissingKey:
+		return true
+	}
+
+	return false
 }
 
 type Settings struct {
@@ -30,13 +64,27 @@ type Settings struct {
 		Target        string `yaml:"target"`
 		baseDirectory string
 	} `yaml:"directories"`
-	configFileModifiedTime time.Time
+	Templates struct {
+		MissingKey MissingKey `yaml:"missing_key"`
+	} `yaml:"templates"`
+	Validation struct {
+		Manifests bool     `yaml:"manifests"`
+		Schemas   []string `yaml:"schemas"`
+		Build     bool     `yaml:"build"`
+	} `yaml:"validation"`
+	Merge      Merge            `yaml:"merge"`
+	Encryption []EncryptionRule `yaml:"encryption"`
+
+	helpersOnce sync.Once
+	helpers     []templateHelper
+	helpersErr  error
 }
 
 func (settings *Settings) Defaults(
 	baseDirectory string,
 	dryRun bool,
 	logConfig LogConfig,
+	missingKey MissingKey,
 ) error {
 	if settings.LogConfig == nil {
 		settings.LogConfig = &LogConfig{}
@@ -46,6 +94,10 @@ func (settings *Settings) Defaults(
 		return fmt.Errorf("error setting log configuration: %w", err)
 	}
 
+	if dryRun {
+		settings.DryRun = true
+	}
+
 	log.Info("Settings")
 	log.Info("Dry run: ", settings.DryRun)
 
@@ -88,6 +140,21 @@ func (settings *Settings) Defaults(
 	}
 	log.Info("Right Delimiter: ", settings.Delimiters.Right)
 
+	if missingKey != "" {
+		if !missingKey.valid() {
+			return fmt.Errorf("unknown missing key behaviour: %s", missingKey)
+		}
+		settings.Templates.MissingKey = missingKey
+	}
+	if settings.Templates.MissingKey == "" {
+		log.Trace("Settings default template missing key: ", settingsDefaults["missing_key"])
+		settings.Templates.MissingKey = MissingKey(settingsDefaults["missing_key"])
+	}
+	log.Info("Template Missing Key: ", settings.Templates.MissingKey)
+
+	settings.Merge = mergeDefaults.override(&settings.Merge)
+	log.Info("Values Merge: ", settings.Merge.Strategy, ", lists: ", settings.Merge.Lists, ", key: ", settings.Merge.Key)
+
 	return nil
 }
 
@@ -125,6 +192,18 @@ func (settings *Settings) Validate() error {
 		}
 	}
 
+	for _, directory := range settings.Validation.Schemas {
+		exist, err := utils.IsDir(settings.pathValidationSchemas(directory))
+		if !exist || err != nil {
+			return fmt.Errorf("validation schemas directory does not exist: %s", directory)
+		}
+	}
+
+	err := validateEncryptionRules(settings.Encryption)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -135,3 +214,11 @@ func (settings *Settings) pathTargets() string {
 func (settings *Settings) pathTemplates() string {
 	return filepath.Join(settings.Directories.baseDirectory, settings.Directories.Templates)
 }
+
+func (settings *Settings) pathValidationSchemas(directory string) string {
+	if filepath.IsAbs(directory) {
+		return directory
+	}
+
+	return filepath.Join(settings.Directories.baseDirectory, directory)
+}
@@ -0,0 +1,193 @@
+package fkt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	sops "github.com/getsops/sops/v3"
+	age "github.com/getsops/sops/v3/age"
+	azkv "github.com/getsops/sops/v3/azkv"
+	gcpkms "github.com/getsops/sops/v3/gcpkms"
+	hcvault "github.com/getsops/sops/v3/hcvault"
+	kms "github.com/getsops/sops/v3/kms"
+	pgp "github.com/getsops/sops/v3/pgp"
+)
+
+// Recipients of encrypted Secrets, following the key groups of the SOPS
+// creation rules. Keys set directly form a single key group.
+type Sops struct {
+	SopsKeyGroup    `yaml:",inline"`
+	KeyGroups       []SopsKeyGroup `yaml:"key_groups"`
+	ShamirThreshold int            `yaml:"shamir_threshold"`
+}
+
+type SopsKeyGroup struct {
+	Age     []string         `yaml:"age"`
+	PGP     []string         `yaml:"pgp"`
+	KMS     []SopsKMSKey     `yaml:"kms"`
+	GCPKMS  []SopsGCPKMSKey  `yaml:"gcp_kms"`
+	AzureKV []SopsAzureKVKey `yaml:"azure_keyvault"`
+	Vault   []string         `yaml:"hc_vault"`
+}
+
+type SopsKMSKey struct {
+	Arn        string            `yaml:"arn"`
+	Role       string            `yaml:"role"`
+	Context    map[string]string `yaml:"context"`
+	AwsProfile string            `yaml:"aws_profile"`
+}
+
+type SopsGCPKMSKey struct {
+	ResourceID string `yaml:"resource_id"`
+}
+
+type SopsAzureKVKey struct {
+	VaultURL string `yaml:"vaultUrl"`
+	Key      string `yaml:"key"`
+	Version  string `yaml:"version"`
+}
+
+// The sops block, or the age public key shorthand.
+func (c *Cluster) sops() *Sops {
+	if c.Sops != nil {
+		return c.Sops
+	}
+	if c.AgePublicKey != "" {
+		return &Sops{
+			SopsKeyGroup: SopsKeyGroup{
+				Age: []string{c.AgePublicKey},
+			},
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) validateSops() error {
+	if c.Sops == nil {
+		return nil
+	}
+	if c.AgePublicKey != "" {
+		return fmt.Errorf("cluster %s: age_public_key and sops are mutually exclusive", *c.path)
+	}
+
+	_, err := c.Sops.keyGroups()
+	if err != nil {
+		return fmt.Errorf("cluster %s: invalid sops keys: %w", *c.path, err)
+	}
+
+	return nil
+}
+
+func (s *Sops) keyGroups() ([]sops.KeyGroup, error) {
+	groups := s.KeyGroups
+	if len(groups) == 0 {
+		groups = []SopsKeyGroup{s.SopsKeyGroup}
+	} else if !s.SopsKeyGroup.empty() {
+		return nil, errors.New("keys and key_groups are mutually exclusive")
+	}
+
+	// Zero requires every key group, SOPS splits the data key with a
+	// threshold of at least two.
+	if s.ShamirThreshold != 0 && (s.ShamirThreshold < 2 || s.ShamirThreshold > len(groups)) {
+		return nil, fmt.Errorf("shamir_threshold must be between 2 and the number of key groups, %d", len(groups))
+	}
+
+	var keyGroups []sops.KeyGroup
+	for index, group := range groups {
+		keyGroup, err := group.keyGroup()
+		if err != nil {
+			return nil, fmt.Errorf("key group %d: %w", index, err)
+		}
+		if len(keyGroup) == 0 {
+			return nil, fmt.Errorf("key group %d: no keys", index)
+		}
+		keyGroups = append(keyGroups, keyGroup)
+	}
+
+	return keyGroups, nil
+}
+
+func (g SopsKeyGroup) empty() bool {
+	return len(g.Age) == 0 && len(g.PGP) == 0 && len(g.KMS) == 0 &&
+		len(g.GCPKMS) == 0 && len(g.AzureKV) == 0 && len(g.Vault) == 0
+}
+
+func (g SopsKeyGroup) keyGroup() (sops.KeyGroup, error) {
+	var keyGroup sops.KeyGroup
+
+	if len(g.Age) > 0 {
+		ageKeys, err := age.MasterKeysFromRecipients(strings.Join(g.Age, ","))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse age public key: %w", err)
+		}
+		for _, ageKey := range ageKeys {
+			keyGroup = append(keyGroup, ageKey)
+		}
+	}
+
+	for _, fingerprint := range g.PGP {
+		keyGroup = append(keyGroup, pgp.NewMasterKeyFromFingerprint(fingerprint))
+	}
+
+	for _, kmsKey := range g.KMS {
+		if kmsKey.Arn == "" {
+			return nil, errors.New("kms key without arn")
+		}
+		context := map[string]*string{}
+		for key, value := range kmsKey.Context {
+			value := value
+			context[key] = &value
+		}
+		masterKey := kms.NewMasterKeyFromArn(kmsKey.Arn, context, kmsKey.AwsProfile)
+		if kmsKey.Role != "" {
+			masterKey.Role = kmsKey.Role
+		}
+		keyGroup = append(keyGroup, masterKey)
+	}
+
+	for _, gcpKMSKey := range g.GCPKMS {
+		if gcpKMSKey.ResourceID == "" {
+			return nil, errors.New("gcp_kms key without resource_id")
+		}
+		keyGroup = append(keyGroup, gcpkms.NewMasterKeyFromResourceID(gcpKMSKey.ResourceID))
+	}
+
+	for _, azureKVKey := range g.AzureKV {
+		if azureKVKey.VaultURL == "" || azureKVKey.Key == "" || azureKVKey.Version == "" {
+			return nil, errors.New("azure_keyvault key requires vaultUrl, key and version")
+		}
+		keyGroup = append(keyGroup, azkv.NewMasterKey(azureKVKey.VaultURL, azureKVKey.Key, azureKVKey.Version))
+	}
+
+	for _, uri := range g.Vault {
+		vaultKey, err := hcvault.NewMasterKeyFromURI(uri)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse hc_vault uri: %s; %w", uri, err)
+		}
+		keyGroup = append(keyGroup, vaultKey)
+	}
+
+	return keyGroup, nil
+}
+
+// Comparable representation of key groups, keys are sorted within groups.
+func recipients(keyGroups []sops.KeyGroup, shamirThreshold int) []string {
+	var groups []string
+	for _, keyGroup := range keyGroups {
+		var keys []string
+		for _, key := range keyGroup {
+			keys = append(keys, fmt.Sprintf("%T:%s", key, key.ToString()))
+		}
+		slices.Sort(keys)
+		groups = append(groups, strings.Join(keys, ","))
+	}
+
+	if shamirThreshold == 0 {
+		shamirThreshold = len(keyGroups)
+	}
+
+	return append(groups, fmt.Sprint("shamir:", shamirThreshold))
+}
@@ -0,0 +1,291 @@
+package fkt
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	decrypt "github.com/getsops/sops/v3/decrypt"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"gopkg.in/yaml.v3"
+)
+
+// Test identities, never used outside of these tests.
+const (
+	testAgeIdentity       = "AGE-SECRET-KEY-1XCD4FQU728A24RH4RLLC60ZQXXWL78894YHADQFFXYM0PZ24DC6SYXJX56"
+	testAgeRecipient      = "age1g4q0wsfhaw2k866zuah47chanu6y052fss3lsyc5n2fadk8x7qwskd06sg"
+	testAgeIdentityOther  = "AGE-SECRET-KEY-1M9WVRL39MSPN8DKEG34JMR8V4ZEJGY9U33ZPHFC3JMUYKNJKM47SZLSDNM"
+	testAgeRecipientOther = "age12tmhs7dh72krdp4pf6xfy225zmv8jzdaets8smqtukkt8qxr8ckqcfs5pe"
+)
+
+const testSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  namespace: app
+stringData:
+  password: hunter2
+`
+
+// Only the given age identities can decrypt, keys of the user are ignored.
+func testAgeIdentities(t *testing.T, identities ...string) {
+	t.Helper()
+
+	t.Setenv("SOPS_AGE_KEY", strings.Join(identities, "\n"))
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	os.Unsetenv("SOPS_AGE_KEY_FILE")
+}
+
+// Generates a PGP key in a temporary GnuPG home and returns its fingerprint.
+func testPGPKey(t *testing.T) string {
+	t.Helper()
+
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		t.Skip("gpg not installed")
+	}
+
+	home := t.TempDir()
+	t.Setenv("GNUPGHOME", home)
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+	})
+
+	output, err := exec.Command(gpg, "--batch", "--passphrase", "", "--quick-generate-key", "fkt test <fkt@example.com>", "default", "default", "never").CombinedOutput()
+	if err != nil {
+		t.Fatalf("cannot generate pgp key: %s; %v", output, err)
+	}
+
+	output, err = exec.Command(gpg, "--batch", "--with-colons", "--list-secret-keys").Output()
+	if err != nil {
+		t.Fatalf("cannot list pgp keys: %v", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+			return fields[9]
+		}
+	}
+	t.Fatal("generated pgp key has no fingerprint")
+
+	return ""
+}
+
+func testEncryptedMetadata(t *testing.T, encrypted []byte) (keyGroups int, shamirThreshold int) {
+	t.Helper()
+
+	tree, err := (&sopsyaml.Store{}).LoadEncryptedFile(encrypted)
+	if err != nil {
+		t.Fatalf("cannot load encrypted document: %v", err)
+	}
+
+	return len(tree.Metadata.KeyGroups), tree.Metadata.ShamirThreshold
+}
+
+func TestSopsRoundTrip(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity, testAgeIdentityOther)
+	fingerprint := testPGPKey(t)
+
+	tests := []struct {
+		name            string
+		keys            Sops
+		keyGroups       int
+		shamirThreshold int
+	}{
+		{
+			name:      "inline age",
+			keys:      Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient, testAgeRecipientOther}}},
+			keyGroups: 1,
+		},
+		{
+			name:      "inline pgp",
+			keys:      Sops{SopsKeyGroup: SopsKeyGroup{PGP: []string{fingerprint}}},
+			keyGroups: 1,
+		},
+		{
+			name:      "inline age and pgp",
+			keys:      Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}, PGP: []string{fingerprint}}},
+			keyGroups: 1,
+		},
+		{
+			name: "key groups",
+			keys: Sops{KeyGroups: []SopsKeyGroup{
+				{Age: []string{testAgeRecipient}},
+				{PGP: []string{fingerprint}},
+			}},
+			keyGroups:       2,
+			shamirThreshold: 2,
+		},
+		{
+			name: "shamir threshold",
+			keys: Sops{
+				KeyGroups: []SopsKeyGroup{
+					{Age: []string{testAgeRecipient}},
+					{Age: []string{testAgeRecipientOther}},
+					{PGP: []string{fingerprint}},
+				},
+				ShamirThreshold: 2,
+			},
+			keyGroups:       3,
+			shamirThreshold: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encrypted, err := encrypt(testSecret, &test.keys, &defaultEncryptionRule)
+			if err != nil {
+				t.Fatalf("encrypt() error = %v", err)
+			}
+			if strings.Contains(string(encrypted), "hunter2") {
+				t.Fatal("encrypted document contains the plaintext")
+			}
+
+			keyGroups, shamirThreshold := testEncryptedMetadata(t, encrypted)
+			if keyGroups != test.keyGroups || shamirThreshold != test.shamirThreshold {
+				t.Errorf("key groups = %d, shamir_threshold = %d, want %d, %d", keyGroups, shamirThreshold, test.keyGroups, test.shamirThreshold)
+			}
+
+			decrypted, err := decrypt.Data(encrypted, "yaml")
+			if err != nil {
+				t.Fatalf("decrypt error = %v", err)
+			}
+
+			var got, want interface{}
+			_ = yaml.Unmarshal(decrypted, &got)
+			_ = yaml.Unmarshal([]byte(testSecret), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decrypted = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSopsShamirThresholdRequiresKeys(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity)
+
+	keys := Sops{
+		KeyGroups: []SopsKeyGroup{
+			{Age: []string{testAgeRecipient}},
+			{Age: []string{testAgeRecipientOther}},
+		},
+		ShamirThreshold: 2,
+	}
+
+	encrypted, err := encrypt(testSecret, &keys, &defaultEncryptionRule)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	_, err = decrypt.Data(encrypted, "yaml")
+	if err == nil {
+		t.Error("decrypt with one of two required key groups succeeded")
+	}
+}
+
+func TestSopsKeyGroupsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		keys Sops
+		err  string
+	}{
+		{
+			name: "inline keys",
+			keys: Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+		},
+		{
+			name: "keys and key groups",
+			keys: Sops{
+				SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}},
+				KeyGroups:    []SopsKeyGroup{{Age: []string{testAgeRecipientOther}}},
+			},
+			err: "keys and key_groups are mutually exclusive",
+		},
+		{
+			name: "threshold of one",
+			keys: Sops{
+				KeyGroups:       []SopsKeyGroup{{Age: []string{testAgeRecipient}}, {Age: []string{testAgeRecipientOther}}},
+				ShamirThreshold: 1,
+			},
+			err: "shamir_threshold must be between 2 and the number of key groups",
+		},
+		{
+			name: "threshold above key groups",
+			keys: Sops{
+				KeyGroups:       []SopsKeyGroup{{Age: []string{testAgeRecipient}}, {Age: []string{testAgeRecipientOther}}},
+				ShamirThreshold: 3,
+			},
+			err: "shamir_threshold must be between 2 and the number of key groups",
+		},
+		{
+			name: "empty key group",
+			keys: Sops{KeyGroups: []SopsKeyGroup{{Age: []string{testAgeRecipient}}, {}}},
+			err:  "key group 1: no keys",
+		},
+		{
+			name: "invalid age recipient",
+			keys: Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{"age1invalid"}}},
+			err:  "cannot parse age public key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.keys.keyGroups()
+			if test.err == "" && err != nil {
+				t.Fatalf("keyGroups() error = %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("keyGroups() error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestClusterValidateSops(t *testing.T) {
+	path := "production"
+	keys := &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipientOther}}}
+
+	tests := []struct {
+		name    string
+		cluster Cluster
+		want    *Sops
+		err     string
+	}{
+		{
+			name:    "age public key",
+			cluster: Cluster{AgePublicKey: testAgeRecipient, path: &path},
+			want:    &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+		},
+		{
+			name:    "sops",
+			cluster: Cluster{Sops: keys, path: &path},
+			want:    keys,
+		},
+		{
+			name:    "age public key and sops",
+			cluster: Cluster{AgePublicKey: testAgeRecipient, Sops: keys, path: &path},
+			err:     "cluster production: age_public_key and sops are mutually exclusive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cluster.validateSops()
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("validateSops() error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateSops() error = %v", err)
+			}
+			if got := test.cluster.sops(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sops() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -3,42 +3,125 @@ package fkt
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
-	sprig "github.com/Masterminds/sprig/v3"
 	utils "github.com/clingclangclick/fkt/utils"
+	sops "github.com/getsops/sops/v3"
+	aes "github.com/getsops/sops/v3/aes"
+	keyservice "github.com/getsops/sops/v3/keyservice"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	version "github.com/getsops/sops/v3/version"
 	log "github.com/sirupsen/logrus"
 
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
-
-	"gopkg.in/yaml.v3"
 )
 
 type Values map[string]interface{}
 
-type K8S struct {
-	Kind string `json:"kind"`
-}
-
+// Merges values in order with the default overwrite strategy.
+//
+// Deprecated: values are merged with the configured merge strategy when
+// processing, see Merge.
 func ProcessValues(values ...*Values) Values {
 	v := Values{}
-
 	for _, sv := range values {
-		for ik, iv := range *sv {
-			v[ik] = iv
+		if sv != nil {
+			v = mergeDefaults.values(v, *sv)
 		}
 	}
 
 	return v
 }
 
-func (v *Values) template(templatePath, targetPath string, settings *Settings, secrets *Secrets) error {
+// Error parsing or executing a template, located in the template file.
+type TemplateError struct {
+	Path     string
+	Line     int
+	Column   int
+	Cluster  string
+	Resource string
+	Message  string
+	Err      error
+}
+
+func (e *TemplateError) Error() string {
+	location := e.Path
+	if e.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Line)
+	}
+	if e.Column > 0 {
+		location = fmt.Sprintf("%s:%d", location, e.Column)
+	}
+
+	if e.Resource == "" {
+		return fmt.Sprintf("%s: cluster %s: %s", location, e.Cluster, e.Message)
+	}
+	return fmt.Sprintf("%s: cluster %s, resource %s: %s", location, e.Cluster, e.Resource, e.Message)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// Template errors of a run, rendering continues past broken templates so
+// every one is reported.
+type TemplateErrors []*TemplateError
+
+func (e TemplateErrors) Error() string {
+	var messages []string
+	for _, templateError := range e {
+		messages = append(messages, templateError.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (e TemplateErrors) Unwrap() []error {
+	var errs []error
+	for _, templateError := range e {
+		errs = append(errs, templateError)
+	}
+
+	return errs
+}
+
+var templateErrorLocation = regexp.MustCompile(`^template: (.+?):(\d+):(?:(\d+):)? `)
+
+// Documents of multi-document files are executed separately, lineOffset is
+// the line the document starts at in the file.
+func newTemplateError(name string, lineOffset int, err error) *TemplateError {
+	templateError := &TemplateError{
+		Path:    name,
+		Message: err.Error(),
+		Err:     err,
+	}
+
+	match := templateErrorLocation.FindStringSubmatch(err.Error())
+	if match != nil {
+		templateError.Path = match[1]
+		templateError.Line, _ = strconv.Atoi(match[2])
+		templateError.Column, _ = strconv.Atoi(match[3])
+		templateError.Message = strings.TrimPrefix(err.Error(), match[0])
+		if templateError.Path == name {
+			templateError.Line += lineOffset
+		}
+	}
+
+	return templateError
+}
+
+func (v *Values) template(templatePath, targetPath string, settings *Settings, secrets *Secrets, report *ResourceReport) error {
 	tfd, err := os.ReadFile(templatePath)
 	if err != nil {
 		return fmt.Errorf("cannot read template file: %s; %w", templatePath, err)
@@ -53,58 +136,36 @@ func (v *Values) template(templatePath, targetPath string, settings *Settings, s
 
 	// Non-YAML files not read in as mulitdoc for k8s kind processing for secrets
 	if !readAsYaml {
-		tpl, err := v.execute(templatePath, string(tfd), settings.Delimiters.Left, settings.Delimiters.Right)
-		if err != nil {
-			return err
-		}
-
-		err = os.RemoveAll(targetPath)
+		tpl, err := v.execute(templatePath, string(tfd), 0, settings)
 		if err != nil {
 			return err
 		}
 
-		err = utils.WriteFile(targetPath, []byte(tpl.String()), 0666, false)
+		action, err := writeFile(targetPath, []byte(tpl.String()), settings.DryRun)
 		if err != nil {
 			return err
 		}
+		report.file(targetPath, action)
 		return nil
 	}
 
-	// Do not update secrets when:
-	// - targetPath file exists (already written)
-	// - targetPath last modified time after modified times of:
-	//   - template file
-	//   - configuration file
-	//   - secrets file, if file exists
-	targetPathIsFile, err := utils.IsFile(targetPath)
-	if targetPathIsFile && err == nil {
-		targetPathInfo, err := os.Stat(targetPath)
-		if err != nil {
-			return err
-		}
-		targetPathModified := targetPathInfo.ModTime().UTC()
+	existingSecrets, err := readEncryptedSecrets(targetPath)
+	if err != nil {
+		return err
+	}
 
-		templatePathInfo, err := os.Stat(templatePath)
-		if err != nil {
-			return err
-		}
-		templatePathModified := templatePathInfo.ModTime().UTC()
-		if err != nil {
-			return err
-		}
-		if targetPathModified.After(templatePathModified) &&
-			targetPathModified.After(settings.configFileModifiedTime) &&
-			secrets.lastModified != nil && targetPathModified.After(*secrets.lastModified) {
-			log.Trace(utils.RelWD(targetPath), " modified after template, config, and secrets file, not modifying")
-			return nil
-		} else {
-			log.Trace("Regenerating ", utils.RelWD(targetPath))
-		}
+	redactedValues, secretValues := *v, *v
+	if secrets.keys != nil {
+		redactedValues = maps.Clone(*v)
+		redactedValues["Secrets"] = redactSecrets(secrets.values)
+		secretValues = maps.Clone(*v)
+		secretValues["Secrets"] = secrets.values
 	}
 
 	fileString := &strings.Builder{}
 	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader([]byte(tfd))))
 	multipleDocs := false
+	position := 0
 	for {
 		buf, err := reader.Read()
 		if err != nil {
@@ -114,6 +175,12 @@ func (v *Values) template(templatePath, targetPath string, settings *Settings, s
 			return err
 		}
 
+		lineOffset := 0
+		if index := bytes.Index(tfd[position:], buf); index >= 0 {
+			lineOffset = bytes.Count(tfd[:position+index], []byte("\n"))
+			position += index + len(buf)
+		}
+
 		if multipleDocs {
 			_, err = fileString.WriteString("---\n")
 			if err != nil {
@@ -121,33 +188,49 @@ func (v *Values) template(templatePath, targetPath string, settings *Settings, s
 			}
 		}
 
-		k8sYaml := &K8S{}
-		err = yaml.Unmarshal(buf, &k8sYaml)
+		t, err := parseTemplate(templatePath, string(buf), lineOffset, settings)
 		if err != nil {
 			return err
 		}
-		if k8sYaml.Kind == "Secret" && secrets.ageKey != "" {
-			log.Info("Adding secrets to values for Secret k8s Kind")
-			(*v)["Secrets"] = secrets.values
-		}
 
-		tpl, err := v.execute(templatePath, string(buf), settings.Delimiters.Left, settings.Delimiters.Right)
+		// Rules match the document rendered with redacted secrets, the secrets
+		// themselves are only rendered into documents that are encrypted.
+		tpl, err := redactedValues.executeTemplate(t, templatePath, lineOffset)
 		if err != nil {
 			return err
 		}
 
-		var yamlFile string
-		if k8sYaml.Kind == "Secret" {
-			if secrets.ageKey == "" {
-				return fmt.Errorf("secret templated but no age public key exists for cluster")
+		rule, err := matchEncryptionRule(secrets.encryption, []byte(tpl.String()))
+		if err != nil {
+			return fmt.Errorf("cannot parse rendered document: %s; %w", templatePath, err)
+		}
+
+		yamlFile := tpl.String()
+		if rule != nil {
+			if secrets.keys == nil {
+				return fmt.Errorf("document to encrypt templated but no sops keys or age public key exist for cluster: %s", templatePath)
 			}
-			yamlFileString, err := encrypt(tpl.String(), secrets.ageKey)
+
+			tpl, err = secretValues.executeTemplate(t, templatePath, lineOffset)
+			if err != nil {
+				return err
+			}
+			secretRule, err := matchEncryptionRule(secrets.encryption, []byte(tpl.String()))
+			if err != nil {
+				return fmt.Errorf("cannot parse rendered document: %s; %w", templatePath, err)
+			}
+			if !reflect.DeepEqual(secretRule, rule) {
+				return &TemplateError{
+					Path:    templatePath,
+					Line:    lineOffset + 1,
+					Message: "document matches a different encryption rule when rendered with Secrets",
+				}
+			}
+
+			yamlFile, err = secrets.encryptDocument(tpl.String(), rule, existingSecrets, targetPath, report)
 			if err != nil {
 				return err
 			}
-			yamlFile = string(yamlFileString)
-		} else {
-			yamlFile = tpl.String()
 		}
 
 		_, err = fileString.WriteString(yamlFile)
@@ -157,75 +240,117 @@ func (v *Values) template(templatePath, targetPath string, settings *Settings, s
 		multipleDocs = true
 	}
 
-	err = os.RemoveAll(targetPath)
+	action, err := writeFile(targetPath, []byte(fileString.String()), settings.DryRun)
 	if err != nil {
 		return err
 	}
+	report.file(targetPath, action)
 
-	err = utils.WriteFile(targetPath, []byte(fileString.String()), 0666, false)
+	return nil
+}
+
+func (v *Values) execute(name, text string, lineOffset int, settings *Settings) (*strings.Builder, error) {
+	t, err := parseTemplate(name, text, lineOffset, settings)
 	if err != nil {
-		return err
+		return &strings.Builder{}, err
 	}
 
-	return nil
+	return v.executeTemplate(t, name, lineOffset)
 }
 
-func (v *Values) execute(name, text, leftDelimiter, rightDelimiter string) (*strings.Builder, error) {
-	t, err := template.New(name).
-		Delims(leftDelimiter, rightDelimiter).
-		Funcs(sprig.FuncMap()).
-		Parse(text)
-
-	t = template.Must(t, err)
+func parseTemplate(name, text string, lineOffset int, settings *Settings) (*template.Template, error) {
+	t, err := settings.newTemplate(name)
 	if err != nil {
-		return &strings.Builder{}, fmt.Errorf("cannot generate template: %s; %w", name, err)
+		return nil, err
 	}
+	t, err = t.Parse(text)
+	if err != nil {
+		return nil, newTemplateError(name, lineOffset, err)
+	}
+
+	return t, nil
+}
 
+func (v *Values) executeTemplate(t *template.Template, name string, lineOffset int) (*strings.Builder, error) {
 	var tpl strings.Builder
 	if err := t.Execute(&tpl, v); err != nil {
-		return &strings.Builder{}, err
+		return &strings.Builder{}, newTemplateError(name, lineOffset, err)
 	}
 
 	return &tpl, nil
 }
 
-func encrypt(yamlString string, ageKey string) ([]byte, error) {
-	var encrypted []byte
+// Encrypts a rendered document, keeping the ciphertext of the existing
+// Secret when its plaintext and recipients are unchanged.
+func (secrets *Secrets) encryptDocument(document string, rule *EncryptionRule, existingSecrets map[string]*encryptedSecret, targetPath string, report *ResourceReport) (string, error) {
+	identity, err := objectIdentity([]byte(document))
+	if err != nil {
+		return "", err
+	}
+
+	existing := existingSecrets[identity]
+	if existing != nil && existing.unchanged(document, secrets.keys, rule) {
+		log.Debug("Secret unchanged, keeping ciphertext: ", identity, " in ", utils.RelWD(targetPath))
+		return string(existing.encrypted), nil
+	}
 
-	_, err := exec.LookPath("sops")
+	encrypted, err := encrypt(document, secrets.keys, rule)
 	if err != nil {
-		return encrypted, err
+		return "", err
 	}
+	report.secret(targetPath)
 
-	cmd := exec.Command(
-		"sops",
-		"--encrypt",
-		"--age", ageKey,
-		"--encrypted-regex", "^(data|stringData)$",
-		"--input-type", "yaml",
-		"--output-type", "yaml",
-		"/dev/stdin",
-	)
+	return string(encrypted), nil
+}
+
+func encrypt(yamlString string, keys *Sops, rule *EncryptionRule) ([]byte, error) {
+	store := sopsyaml.Store{}
 
-	stdin, err := cmd.StdinPipe()
+	branches, err := store.LoadPlainFile([]byte(yamlString))
 	if err != nil {
-		return encrypted, err
+		return nil, fmt.Errorf("cannot load secret for encryption: %w", err)
 	}
 
-	errs := make(chan error, 1)
-	go func() {
-		defer stdin.Close()
-		_, err := io.WriteString(stdin, yamlString)
-		errs <- err
-	}()
+	keyGroups, err := keys.keyGroups()
+	if err != nil {
+		return nil, fmt.Errorf("invalid sops keys: %w", err)
+	}
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:        keyGroups,
+			ShamirThreshold:  keys.ShamirThreshold,
+			EncryptedRegex:   rule.EncryptedRegex,
+			EncryptedSuffix:  rule.EncryptedSuffix,
+			UnencryptedRegex: rule.UnencryptedRegex,
+			Version:          version.Version,
+		},
+	}
 
-	if err := <-errs; err != nil {
-		return encrypted, err
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices(
+		[]keyservice.KeyServiceClient{keyservice.NewLocalClient()},
+	)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("cannot generate data key: %w", errors.Join(errs...))
+	}
+
+	cipher := aes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt secret: %w", err)
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(
+		mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt secret mac: %w", err)
 	}
 
-	encrypted, _ = cmd.Output()
+	encrypted, err := store.EmitEncryptedFile(tree)
 	if err != nil {
-		return encrypted, err
+		return nil, fmt.Errorf("cannot emit encrypted secret: %w", err)
 	}
 
 	return encrypted, nil
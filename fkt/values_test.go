@@ -0,0 +1,169 @@
+package fkt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	decrypt "github.com/getsops/sops/v3/decrypt"
+)
+
+func testSettings(t *testing.T) *Settings {
+	t.Helper()
+
+	settings := &Settings{}
+	settings.Directories.baseDirectory = t.TempDir()
+	settings.Directories.Templates = "templates"
+	settings.Delimiters.Left = "[[["
+	settings.Delimiters.Right = "]]]"
+	settings.Templates.MissingKey = DefaultMissingKey
+
+	return settings
+}
+
+func TestTemplateSecrets(t *testing.T) {
+	testAgeIdentities(t, testAgeIdentity)
+
+	secretDocument := `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+stringData:
+  password: [[[ .Secrets.password ]]]
+`
+	configMapDocument := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: [[[ .mode ]]]
+`
+
+	tests := []struct {
+		name      string
+		template  string
+		keys      *Sops
+		errLine   int
+		plaintext []string
+	}{
+		{
+			name:      "encrypted document",
+			template:  configMapDocument + "---\n" + secretDocument,
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"mode: a"},
+		},
+		{
+			name:      "unencrypted document",
+			template:  strings.Replace(configMapDocument, ".mode", ".Secrets.password", 1),
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"mode: redacted"},
+		},
+		{
+			name:      "unencrypted document without keys",
+			template:  configMapDocument + "---\n" + strings.Replace(configMapDocument, ".mode", "$.Secrets.password", 1),
+			plaintext: []string{"mode: a", "mode: <no value>"},
+		},
+		{
+			name: "unencrypted document through a helper",
+			template: `[[[ define "password" ]]][[[ .Secrets.password ]]][[[ end ]]]
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  password: [[[ include "password" . ]]]
+`,
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"password: redacted"},
+		},
+		{
+			name:      "unencrypted document with all values",
+			template:  strings.Replace(configMapDocument, "[[[ .mode ]]]", "'[[[ toJson . ]]]'", 1),
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{`"Secrets":{"password":"redacted"}`},
+		},
+		{
+			name:      "unencrypted document with index",
+			template:  strings.Replace(configMapDocument, ".mode", `index . "Secrets" "password"`, 1),
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"mode: redacted"},
+		},
+		{
+			name:      "unencrypted document with tpl",
+			template:  strings.Replace(configMapDocument, ".mode", "tpl \"[[[ .Secrets.password ]]]\" .", 1),
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"mode: redacted"},
+		},
+		{
+			name:     "encryption rule depends on secrets",
+			template: strings.Replace(secretDocument, "kind: Secret", `kind: [[[ if eq .Secrets.password "redacted" ]]]Secret[[[ else ]]]ConfigMap[[[ end ]]]`, 1),
+			keys:     &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			errLine:  1,
+		},
+		{
+			name:      "unencrypted document with keys",
+			template:  configMapDocument,
+			keys:      &Sops{SopsKeyGroup: SopsKeyGroup{Age: []string{testAgeRecipient}}},
+			plaintext: []string{"mode: a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := testSettings(t)
+			templatePath := filepath.Join(settings.Directories.baseDirectory, "template.yaml")
+			targetPath := filepath.Join(settings.Directories.baseDirectory, "target.yaml")
+			err := os.WriteFile(templatePath, []byte(test.template), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values := Values{"mode": "a"}
+			secrets := &Secrets{
+				values:     Values{"password": "hunter2"},
+				keys:       test.keys,
+				encryption: []EncryptionRule{defaultEncryptionRule},
+			}
+			err = values.template(templatePath, targetPath, settings, secrets, &ResourceReport{report: &Report{}})
+
+			if test.errLine > 0 {
+				var templateError *TemplateError
+				if !errors.As(err, &templateError) || templateError.Line != test.errLine {
+					t.Fatalf("template() error = %v, want a template error on line %d", err, test.errLine)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("template() error = %v", err)
+			}
+
+			rendered, err := os.ReadFile(targetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(rendered), "hunter2") {
+				t.Errorf("rendered file contains the secret:\n%s", rendered)
+			}
+			for _, plaintext := range test.plaintext {
+				if !strings.Contains(string(rendered), plaintext) {
+					t.Errorf("rendered file does not contain %q:\n%s", plaintext, rendered)
+				}
+			}
+
+			for _, document := range strings.Split(string(rendered), "\n---\n") {
+				if !strings.Contains(document, "kind: Secret") {
+					continue
+				}
+				decrypted, err := decrypt.Data([]byte(document), "yaml")
+				if err != nil {
+					t.Fatalf("decrypt error = %v", err)
+				}
+				if !strings.Contains(string(decrypted), "password: hunter2") {
+					t.Errorf("decrypted secret does not contain the password:\n%s", decrypted)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/alecthomas/kong"
 
@@ -12,12 +14,11 @@ import (
 	utils "github.com/clingclangclick/fkt/utils"
 )
 
-var CLI struct {
-	DryRun        bool   `short:"d" help:"Dry run and return error if changes are needed" env:"DRY_RUN" default:"false"`
-	Validate      bool   `short:"v" help:"Validate configuration" env:"VALIDATE" default:"false"`
-	ConfigFile    string `type:"existingfile" short:"f" help:"YAML configuration file" env:"CONFIG_FILE"`
-	BaseDirectory string `type:"existingdirectory" short:"b" help:"Base directory" env:"BASE_DIRECTORY" default:"${base_directory}"`
-	SopsAgeKey    string `short:"s" help:"Sops age key for decryption" env:"SOPS_AGE_KEY"`
+type Globals struct {
+	ConfigFile    []string `type:"path" short:"f" help:"YAML configuration file, may be repeated" env:"CONFIG_FILE"`
+	BaseDirectory string   `type:"existingdirectory" short:"b" help:"Base directory" env:"BASE_DIRECTORY" default:"${base_directory}"`
+	SopsAgeKey    string   `short:"s" help:"Sops age key for decryption" env:"SOPS_AGE_KEY"`
+	MissingKey    string   `help:"Template behaviour for missing map keys, one of default, zero or error, overrides settings" env:"MISSING_KEY"`
 	Logging       struct {
 		Level  string `enum:"default,none,trace,debug,info,warn,error" short:"l" help:"Log level" env:"LOG_LEVEL" default:"${logging_level}"`
 		File   string `type:"path" short:"o" help:"Log file" env:"LOG_FILE"`
@@ -25,6 +26,31 @@ var CLI struct {
 	} `embed:"" prefix:"logging."`
 }
 
+var CLI struct {
+	Globals
+
+	Render   RenderCmd   `cmd:"" default:"withargs" help:"Render cluster overlays (default)."`
+	Diff     DiffCmd     `cmd:"" help:"Render in memory and print differences to the cluster overlays."`
+	Validate ValidateCmd `cmd:"" help:"Validate configuration."`
+	Build    BuildCmd    `cmd:"" help:"Render in memory and print the kustomize build of a cluster."`
+	List     ListCmd     `cmd:"" help:"List clusters, resources or templates."`
+	Explain  ExplainCmd  `cmd:"" help:"Explain the values and paths for a cluster resource."`
+	Schema   SchemaCmd   `cmd:"" help:"Print the JSON Schema of the configuration file."`
+}
+
+// Returned by commands to exit with a specific code, err is reported if set.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
 func main() {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -40,69 +66,83 @@ func main() {
 		},
 	)
 
-	_, err = os.Stat(CLI.BaseDirectory)
+	err = ctx.Run(&CLI.Globals)
+
+	var exit exitError
+	if errors.As(err, &exit) {
+		if exit.err != nil {
+			ctx.Errorf("%s", exit.err)
+		}
+		ctx.Exit(exit.code)
+	}
+	ctx.FatalIfErrorf(err)
+
+	ctx.Exit(0)
+}
+
+func (globals *Globals) load(dryRun bool) (*fkt.Config, error) {
+	_, err := os.Stat(globals.BaseDirectory)
 	if os.IsNotExist(err) {
-		fmt.Println("Base Directory does not exist.")
-		ctx.Exit(1)
+		return nil, errors.New("base directory does not exist")
 	}
 
-	if CLI.ConfigFile == "" {
-		fmt.Print("Configuration file not supplied.\n\n")
-		_ = ctx.PrintUsage(true)
-		ctx.Exit(1)
+	if len(globals.ConfigFile) == 0 {
+		return nil, errors.New("configuration file not supplied")
 	}
-	_, err = os.Stat(CLI.ConfigFile)
-	if os.IsNotExist(err) {
-		fmt.Println("Configuration file does not exist.")
-		ctx.Exit(1)
+	for _, configFile := range globals.ConfigFile {
+		_, err = os.Stat(configFile)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("configuration file does not exist: %s", configFile)
+		}
 	}
 
-	config, err := fkt.LoadConfig(CLI.ConfigFile)
+	config, err := fkt.LoadConfig(globals.ConfigFile...)
 	if err != nil {
-		log.Error("Error loading config file: ", CLI.ConfigFile, " (", err, ")")
-		ctx.Exit(1)
+		return nil, fmt.Errorf("error loading config file: %s (%w)", globals.configFiles(), err)
 	}
 
-	err = config.Settings.Defaults(CLI.BaseDirectory, CLI.DryRun, fkt.LogConfig{
-		Level:  fkt.LogLevel(CLI.Logging.Level),
-		Format: fkt.LogFormat(CLI.Logging.Format),
-		File:   CLI.Logging.File,
-	})
+	err = config.Settings.Defaults(globals.BaseDirectory, dryRun, fkt.LogConfig{
+		Level:  fkt.LogLevel(globals.Logging.Level),
+		Format: fkt.LogFormat(globals.Logging.Format),
+		File:   globals.Logging.File,
+	}, fkt.MissingKey(globals.MissingKey))
 	if err != nil {
-		log.Error("Error setting configuration; ", err)
-		ctx.Exit(1)
+		return nil, fmt.Errorf("error setting configuration; %w", err)
 	}
 
-	log.Info("Loaded configuration: ", utils.RelWD(CLI.ConfigFile))
+	for _, configFile := range globals.ConfigFile {
+		log.Info("Loaded configuration: ", utils.RelWD(configFile))
+	}
 
-	if CLI.SopsAgeKey != "" {
+	if globals.SopsAgeKey != "" {
 		log.Info("Setting SOPS_AGE_KEY")
-		os.Setenv("SOPS_AGE_KEY", CLI.SopsAgeKey)
+		os.Setenv("SOPS_AGE_KEY", globals.SopsAgeKey)
 	}
 
 	log.Debug("Loaded configuration file")
 
-	settings := config.Settings
+	return config, nil
+}
 
-	err = settings.Validate()
+func (globals *Globals) configFiles() string {
+	return strings.Join(globals.ConfigFile, ", ")
+}
+
+func (globals *Globals) validate(dryRun bool) (*fkt.Config, error) {
+	config, err := globals.load(dryRun)
 	if err != nil {
-		log.Error("Error validating settings: ", CLI.ConfigFile, " (", err, ")")
-		ctx.Exit(1)
+		return nil, err
 	}
 
-	err = config.Validate()
+	err = config.Settings.Validate()
 	if err != nil {
-		log.Error("Error validating configuration: ", CLI.ConfigFile, " (", err, ")")
-		ctx.Exit(1)
+		return nil, fmt.Errorf("error validating settings: %s (%w)", globals.configFiles(), err)
 	}
 
-	if !CLI.Validate {
-		err = config.Process()
-		if err != nil {
-			log.Error("Error processing configuration: ", CLI.ConfigFile, " (", err, ")")
-			ctx.Exit(1)
-		}
+	err = config.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error validating configuration: %s (%w)", globals.configFiles(), err)
 	}
 
-	ctx.Exit(0)
+	return config, nil
 }
@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const diffContext = 3
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+func UnifiedDiff(path string, before, after []byte) string {
+	if (before == nil) == (after == nil) && string(before) == string(after) {
+		return ""
+	}
+
+	from, to := "a/"+path, "b/"+path
+	if before == nil {
+		from = "/dev/null"
+	}
+	if after == nil {
+		to = "/dev/null"
+	}
+
+	ops := diffLines(splitLines(string(before)), splitLines(string(after)))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Extend the hunk until a run of unchanged lines is long enough to
+		// separate it from the next change.
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+				continue
+			}
+			if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		hunkStart := max(start-diffContext, 0)
+		hunkEnd := min(end+diffContext, len(ops))
+
+		aLine, bLine := 0, 0
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		aCount, bCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = hunkEnd
+	}
+
+	return sb.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Myers' O(ND) difference algorithm in linear space, recursing on the middle
+// snake of the edit script. Removals of a change are listed before its
+// additions.
+func diffLines(a, b []string) []diffOp {
+	ops := appendDiff(nil, a, b)
+
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(ops) && ops[end].kind != ' ' {
+			end++
+		}
+		slices.SortStableFunc(ops[start:end], func(x, y diffOp) int {
+			return int(y.kind) - int(x.kind)
+		})
+		start = end
+	}
+
+	return ops
+}
+
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+	default:
+		x, y, u, v := middleSnake(a, b)
+		ops = appendDiff(ops, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			ops = append(ops, diffOp{' ', line})
+		}
+		ops = appendDiff(ops, a[u:], b[v:])
+	}
+
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	return ops
+}
+
+// Returns the start and end of the middle snake of an edit script of a and
+// b, searching forward from the start and backward from the end until the
+// paths overlap.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u++
+				v++
+			}
+			forward[offset+k] = u
+
+			reverse := delta - k
+			if odd && reverse >= -(d-1) && reverse <= d-1 && u+backward[offset+reverse] >= n {
+				return x, y, u, v
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var reverseX int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				reverseX = backward[offset+k+1]
+			} else {
+				reverseX = backward[offset+k-1] + 1
+			}
+			reverseY := reverseX - k
+			endX, endY := reverseX, reverseY
+			for endX < n && endY < m && a[n-1-endX] == b[m-1-endY] {
+				endX++
+				endY++
+			}
+			backward[offset+k] = endX
+
+			forwardK := delta - k
+			if !odd && forwardK >= -d && forwardK <= d && endX+forward[offset+forwardK] >= n {
+				return n - endX, m - endY, n - reverseX, m - reverseY
+			}
+		}
+	}
+
+	return 0, 0, 0, 0
+}
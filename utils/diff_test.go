@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		diff   string
+	}{
+		{
+			name:   "unchanged",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+		},
+		{
+			name:  "added",
+			after: "a\n",
+			diff:  "--- /dev/null\n+++ b/file\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:   "changed line",
+			before: "a\nb\nc\n",
+			after:  "a\nx\nc\n",
+			diff:   "--- a/file\n+++ b/file\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		{
+			name:   "rewritten",
+			before: "a\nb\nc\n",
+			after:  "x\ny\n",
+			diff:   "--- a/file\n+++ b/file\n@@ -1,3 +1,2 @@\n-a\n-b\n-c\n+x\n+y\n",
+		},
+		{
+			name:   "separate hunks",
+			before: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			after:  "x\n1\n2\n3\n4\n5\n6\n7\n8\ny\n",
+			diff:   "--- a/file\n+++ b/file\n@@ -1,4 +1,4 @@\n-a\n+x\n 1\n 2\n 3\n@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+y\n",
+		},
+		{
+			name:   "no newline at end of file",
+			before: "a\n",
+			after:  "a\nb",
+			diff:   "--- a/file\n+++ b/file\n@@ -1 +1,2 @@\n a\n+b\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var before, after []byte
+			if test.before != "" {
+				before = []byte(test.before)
+			}
+			if test.after != "" {
+				after = []byte(test.after)
+			}
+
+			diff := UnifiedDiff("file", before, after)
+			if diff != test.diff {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", diff, test.diff)
+			}
+		})
+	}
+}
+
+// Length of the shortest edit script of a and b.
+func testEditDistance(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+func TestDiffLinesMinimal(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		result := make([]string, random.Intn(20))
+		for i := range result {
+			result[i] = string(rune('a' + random.Intn(4)))
+		}
+		return result
+	}
+
+	for i := 0; i < 1000; i++ {
+		a, b := lines(), lines()
+		ops := diffLines(a, b)
+
+		var before, after []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				before = append(before, op.line)
+			}
+			if op.kind != '-' {
+				after = append(after, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(before, "") != strings.Join(a, "") || strings.Join(after, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) does not reproduce the inputs: %v", a, b, ops)
+		}
+		if want := testEditDistance(a, b); edits != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeRewrite(t *testing.T) {
+	var before, after []string
+	for i := 0; i < 3000; i++ {
+		before = append(before, fmt.Sprintf("before %d\n", i))
+		after = append(after, fmt.Sprintf("after %d\n", i))
+	}
+
+	var start, end runtime.MemStats
+	runtime.ReadMemStats(&start)
+	ops := diffLines(before, after)
+	runtime.ReadMemStats(&end)
+
+	if len(ops) != 6000 {
+		t.Errorf("diffLines() = %d operations, want 6000", len(ops))
+	}
+	if allocated := end.TotalAlloc - start.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("diffLines() allocated %d MB", allocated>>20)
+	}
+}
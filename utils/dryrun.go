@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Changes made during a dry run are recorded here instead of on disk. Reads
+// through this package observe them, so a dry run renders exactly as a real
+// run would.
+var dryRunView = struct {
+	sync.Mutex
+	files   map[string][]byte
+	dirs    map[string]struct{}
+	removed map[string]struct{}
+}{
+	files:   map[string][]byte{},
+	dirs:    map[string]struct{}{},
+	removed: map[string]struct{}{},
+}
+
+type dirEntry struct {
+	name string
+	dir  bool
+}
+
+func (d dirEntry) Name() string { return d.name }
+func (d dirEntry) IsDir() bool  { return d.dir }
+func (d dirEntry) Type() fs.FileMode {
+	if d.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+func (d dirEntry) Info() (fs.FileInfo, error) {
+	return nil, errors.New("dry-run, no file info for " + d.name)
+}
+
+func viewKey(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+func isUnder(path, parent string) bool {
+	return path == parent || strings.HasPrefix(path, parent+string(filepath.Separator))
+}
+
+// Returns whether the view has an answer for path; exists and dir are only
+// meaningful when it does.
+func viewLookup(path string) (found, exists, dir bool) {
+	key := viewKey(path)
+
+	dryRunView.Lock()
+	defer dryRunView.Unlock()
+
+	if _, ok := dryRunView.files[key]; ok {
+		return true, true, false
+	}
+	// Parents of written files are recorded as directories.
+	if _, ok := dryRunView.dirs[key]; ok {
+		return true, true, true
+	}
+	for dir := key; ; dir = filepath.Dir(dir) {
+		if _, ok := dryRunView.removed[dir]; ok {
+			return true, false, false
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return false, false, false
+}
+
+func viewWrite(path string, b []byte) {
+	key := viewKey(path)
+
+	dryRunView.Lock()
+	defer dryRunView.Unlock()
+
+	dryRunView.files[key] = append([]byte{}, b...)
+	for dir := filepath.Dir(key); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		dryRunView.dirs[dir] = struct{}{}
+	}
+}
+
+func viewMkDir(path string) {
+	key := viewKey(path)
+
+	dryRunView.Lock()
+	defer dryRunView.Unlock()
+
+	for dir := key; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		dryRunView.dirs[dir] = struct{}{}
+	}
+}
+
+func viewRemove(path string) {
+	key := viewKey(path)
+
+	dryRunView.Lock()
+	defer dryRunView.Unlock()
+
+	for file := range dryRunView.files {
+		if isUnder(file, key) {
+			delete(dryRunView.files, file)
+		}
+	}
+	for dir := range dryRunView.dirs {
+		if isUnder(dir, key) {
+			delete(dryRunView.dirs, dir)
+		}
+	}
+	dryRunView.removed[key] = struct{}{}
+}
+
+func ReadFile(path string) ([]byte, error) {
+	key := viewKey(path)
+
+	dryRunView.Lock()
+	b, ok := dryRunView.files[key]
+	dryRunView.Unlock()
+	if ok {
+		return append([]byte{}, b...), nil
+	}
+
+	if found, exists, _ := viewLookup(path); found && !exists {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+
+	return os.ReadFile(path)
+}
+
+func ReadDir(path string) ([]fs.DirEntry, error) {
+	key := viewKey(path)
+
+	found, exists, dir := viewLookup(path)
+	if found && (!exists || !dir) {
+		return nil, &fs.PathError{Op: "readdirent", Path: path, Err: fs.ErrNotExist}
+	}
+
+	entries := map[string]fs.DirEntry{}
+
+	diskEntries, err := os.ReadDir(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range diskEntries {
+		if found, exists, _ := viewLookup(filepath.Join(path, entry.Name())); found && !exists {
+			continue
+		}
+		entries[entry.Name()] = entry
+	}
+
+	dryRunView.Lock()
+	for file := range dryRunView.files {
+		if filepath.Dir(file) == key {
+			entries[filepath.Base(file)] = dirEntry{name: filepath.Base(file)}
+		}
+	}
+	for dir := range dryRunView.dirs {
+		if filepath.Dir(dir) == key {
+			entries[filepath.Base(dir)] = dirEntry{name: filepath.Base(dir), dir: true}
+		}
+	}
+	dryRunView.Unlock()
+
+	if err != nil && len(entries) == 0 && !found {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]fs.DirEntry, 0, len(names))
+	for _, name := range names {
+		result = append(result, entries[name])
+	}
+
+	return result, nil
+}
+
+func RemoveAll(path string, dryRun bool) error {
+	if dryRun {
+		viewRemove(path)
+		return nil
+	}
+
+	return os.RemoveAll(path)
+}
+
+// Returns a unified diff, relative to base, for every file a dry run
+// would add, modify or remove.
+func DryRunDiff(base string) ([]string, error) {
+	baseKey := viewKey(base)
+
+	dryRunView.Lock()
+	defer dryRunView.Unlock()
+
+	changes := map[string][2][]byte{}
+
+	for file, after := range dryRunView.files {
+		before, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		changes[file] = [2][]byte{before, after}
+	}
+
+	for removed := range dryRunView.removed {
+		err := filepath.WalkDir(removed, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if _, ok := dryRunView.files[path]; ok {
+				return nil
+			}
+			before, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			changes[path] = [2][]byte{before, nil}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var diffs []string
+	for _, path := range paths {
+		change := changes[path]
+		if change[0] != nil && change[1] != nil && string(change[0]) == string(change[1]) {
+			continue
+		}
+		relPath, err := filepath.Rel(baseKey, path)
+		if err != nil {
+			relPath = path
+		}
+		if diff := UnifiedDiff(filepath.ToSlash(relPath), change[0], change[1]); diff != "" {
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs, nil
+}
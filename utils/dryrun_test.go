@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadDirDryRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, path string)
+		entries []string
+		missing bool
+	}{
+		{
+			name: "directory only in view",
+			prepare: func(t *testing.T, path string) {
+				if err := MkDir(path, true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			entries: []string{},
+		},
+		{
+			name: "files only in view",
+			prepare: func(t *testing.T, path string) {
+				if err := WriteFile(filepath.Join(path, "b.yaml"), []byte("b"), 0o644, true); err != nil {
+					t.Fatal(err)
+				}
+				if err := MkDir(filepath.Join(path, "a"), true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			entries: []string{"a", "b.yaml"},
+		},
+		{
+			name: "directory on disk with view changes",
+			prepare: func(t *testing.T, path string) {
+				if err := os.MkdirAll(filepath.Join(path, "removed"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(path, "kept.yaml"), []byte("kept"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := RemoveAll(filepath.Join(path, "removed"), true); err != nil {
+					t.Fatal(err)
+				}
+				if err := WriteFile(filepath.Join(path, "written.yaml"), []byte("written"), 0o644, true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			entries: []string{"kept.yaml", "written.yaml"},
+		},
+		{
+			name: "directory written again after removal in view",
+			prepare: func(t *testing.T, path string) {
+				if err := os.MkdirAll(filepath.Join(path, "nested"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(path, "old.yaml"), []byte("old"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := RemoveAll(path, true); err != nil {
+					t.Fatal(err)
+				}
+				if err := WriteFile(filepath.Join(path, "new.yaml"), []byte("new"), 0o644, true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			entries: []string{"new.yaml"},
+		},
+		{
+			name: "directory removed in view",
+			prepare: func(t *testing.T, path string) {
+				if err := os.MkdirAll(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := RemoveAll(path, true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			missing: true,
+		},
+		{
+			name:    "directory missing",
+			prepare: func(t *testing.T, path string) {},
+			missing: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "target")
+			test.prepare(t, path)
+
+			entries, err := ReadDir(path)
+			if test.missing {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("ReadDir() error = %v, want not exist", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadDir() error = %v", err)
+			}
+
+			names := []string{}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if !reflect.DeepEqual(names, test.entries) {
+				t.Errorf("ReadDir() = %q, want %q", names, test.entries)
+			}
+		})
+	}
+}
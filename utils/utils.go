@@ -1,15 +1,14 @@
 package utils
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"time"
+	"slices"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 func RelWD(path string) string {
@@ -19,56 +18,73 @@ func RelWD(path string) string {
 	return relPath
 }
 
-func RemoveExtraFilesAndDirectories(sourceDir, targetDir string, dryRun bool) error {
-	sourceItems, err := os.ReadDir(sourceDir)
+func RemoveExtraFilesAndDirectories(sourceDir, targetDir string, dryRun bool) ([]string, error) {
+	sourceItems, err := ReadDir(sourceDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	targetItems := make(map[string]struct{})
 
 	targetFiles, err := os.ReadDir(targetDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	for _, item := range targetFiles {
 		targetItems[item.Name()] = struct{}{}
 	}
 
+	var removed []string
 	for _, item := range sourceItems {
 		if _, exists := targetItems[item.Name()]; !exists {
 			itemPath := filepath.Join(sourceDir, item.Name())
 
-			if dryRun && IsExist(itemPath) {
-				return fmt.Errorf("dry-run, entry to be removed: %s", itemPath)
+			if err := RemoveAll(itemPath, dryRun); err != nil {
+				return removed, err
 			}
-
 			if item.IsDir() {
-				if err := os.RemoveAll(itemPath); err != nil {
-					return err
-				}
 				log.Debug("Removed target directory: ", itemPath)
 			} else {
-				if err := os.Remove(itemPath); err != nil {
-					return err
-				}
 				log.Debug("Removed target file: ", itemPath)
 			}
+			removed = append(removed, itemPath)
 		}
 	}
 
-	return nil
+	return removed, nil
+}
+
+func RemoveFilesAndDirectoriesExcept(dir string, names []string, dryRun bool) ([]string, error) {
+	items, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, item := range items {
+		if slices.Contains(names, item.Name()) {
+			continue
+		}
+		itemPath := filepath.Join(dir, item.Name())
+
+		if err := RemoveAll(itemPath, dryRun); err != nil {
+			return removed, err
+		}
+		log.Debug("Removed target: ", itemPath)
+		removed = append(removed, itemPath)
+	}
+
+	return removed, nil
 }
 
 func MkDir(path string, dryRun bool) error {
 	exists, err := IsDir(path)
 	if dryRun {
 		if !exists {
-			return fmt.Errorf("dry-run, %s does not exist or is not a directory", path)
-		} else {
-			return nil
+			viewMkDir(path)
 		}
+		return nil
 	}
 	if os.IsNotExist(err) {
 		err = os.MkdirAll(path, 0777)
@@ -79,6 +95,13 @@ func MkDir(path string, dryRun bool) error {
 }
 
 func IsDir(path string) (bool, error) {
+	if found, exists, dir := viewLookup(path); found {
+		if !exists {
+			return false, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return dir, nil
+	}
+
 	s, err := os.Stat(path)
 	if err != nil {
 		return false, err
@@ -88,6 +111,13 @@ func IsDir(path string) (bool, error) {
 }
 
 func IsFile(path string) (bool, error) {
+	if found, exists, dir := viewLookup(path); found {
+		if !exists {
+			return false, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		return !dir, nil
+	}
+
 	s, err := os.Stat(path)
 	if err != nil {
 		return false, err
@@ -96,22 +126,18 @@ func IsFile(path string) (bool, error) {
 }
 
 func IsExist(path string) bool {
+	if found, exists, _ := viewLookup(path); found {
+		return exists
+	}
+
 	_, err := os.Stat(path)
 	return !errors.Is(err, os.ErrNotExist)
 }
 
 func WriteFile(path string, b []byte, mode uint32, dryRun bool) error {
 	if dryRun {
-		existingData, err := os.ReadFile(path)
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("dry-run, error reading existing file: %w", err)
-		}
-
-		if bytes.Equal(existingData, b) {
-			return nil
-		}
-
-		return errors.New("dry-run, file contents would be changed")
+		viewWrite(path, b)
+		return nil
 	}
 
 	err := os.WriteFile(path, b, os.FileMode(mode))
@@ -141,29 +167,3 @@ func ContainsKustomization(path string) bool {
 	log.Trace("No kustomizations in ", path)
 	return false
 }
-
-func SOPSLastModified(fileBytes []byte) (time.Time, error) {
-	sopsStruct := struct {
-		Sops struct {
-			LastModified string `yaml:"lastmodified"`
-		} `yaml:"sops"`
-	}{}
-
-	err := yaml.Unmarshal(fileBytes, &sopsStruct)
-	if err != nil {
-		return time.Time{}, err
-	}
-	iso8601Format := "2006-01-02T15:04:05Z"
-
-	log.Trace("Found lastmodified for sops k8s yaml: ", sopsStruct.Sops.LastModified)
-
-	t, err := time.Parse(iso8601Format, sopsStruct.Sops.LastModified)
-	if err != nil {
-		return time.Time{}, err
-	}
-
-	tUTC := t.UTC()
-
-	log.Debug("Parsed lastmdified time as ", tUTC)
-	return t, err
-}